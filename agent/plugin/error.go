@@ -35,15 +35,23 @@ func (e *DeprecatedNameErrors) Errors() []DeprecatedNameError {
 	}
 
 	sort.Slice(errs, func(i, j int) bool {
-		if errs[i].old == errs[j].old {
-			return errs[i].new < errs[j].new
-		}
-		return errs[i].old < errs[j].old
+		return errs[i].less(errs[j])
 	})
 
 	return errs
 }
 
+// GroupByReplacement returns, for each replacement name, the sorted list of
+// deprecated names that it replaces
+func (e *DeprecatedNameErrors) GroupByReplacement() map[string][]string {
+	groups := map[string][]string{}
+	// Errors are sorted by old name, so each group is built in sorted order
+	for _, err := range e.Errors() {
+		groups[err.new] = append(groups[err.new], err.old)
+	}
+	return groups
+}
+
 // Error returns each contained error on a new line
 func (e *DeprecatedNameErrors) Error() string {
 	builder := strings.Builder{}
@@ -99,20 +107,47 @@ func (e *DeprecatedNameErrors) Is(target error) bool {
 }
 
 // DeprecatedNameError contains information about environment variable names that
-// are deprecated. Both the deprecated name and its replacement are held
+// are deprecated. Both the deprecated name and its replacement are held, along
+// with an optional link to documentation about migrating to the replacement
 type DeprecatedNameError struct {
-	old string
-	new string
+	old    string
+	new    string
+	docURL string
 }
 
 func NewDeprecatedNameError(oldName, newName string) DeprecatedNameError {
 	return DeprecatedNameError{old: oldName, new: newName}
 }
 
+// NewDeprecatedNameErrorWithDocURL is like NewDeprecatedNameError, but the
+// error also links to documentation at docURL, such as a migration guide
+func NewDeprecatedNameErrorWithDocURL(oldName, newName, docURL string) DeprecatedNameError {
+	return DeprecatedNameError{old: oldName, new: newName, docURL: docURL}
+}
+
 func (e *DeprecatedNameError) Error() string {
-	return fmt.Sprintf(" deprecated: %q\nreplacement: %q\n", e.old, e.new)
+	msg := fmt.Sprintf(" deprecated: %q\nreplacement: %q\n", e.old, e.new)
+	if e.docURL != "" {
+		msg += fmt.Sprintf("        see: %s\n", e.docURL)
+	}
+	return msg
 }
 
+// less orders errors by old name, then new name, then documentation URL
+func (e DeprecatedNameError) less(other DeprecatedNameError) bool {
+	if e.old != other.old {
+		return e.old < other.old
+	}
+	if e.new != other.new {
+		return e.new < other.new
+	}
+	return e.docURL < other.docURL
+}
+
+// Is returns true if and only if target wraps a DeprecatedNameError with the
+// same names and documentation URL. The URL is not ignored, so that the same
+// deprecation with two different URLs (which is likely a mistake) shows up as
+// two errors in a DeprecatedNameErrors, rather than one of them being lost.
 func (e *DeprecatedNameError) Is(target error) bool {
 	if e == nil {
 		return target == nil
@@ -123,5 +158,129 @@ func (e *DeprecatedNameError) Is(target error) bool {
 		return false
 	}
 
-	return e.old == targetErr.old && e.new == targetErr.new
+	return *e == *targetErr
+}
+
+// PluginDeprecations contains a set of PluginDeprecation
+type PluginDeprecations struct {
+	errs map[PluginDeprecation]unit
+}
+
+// IsEmpty return true if and only if `e` contains no errors
+func (e *PluginDeprecations) IsEmpty() bool {
+	return e == nil || len(e.errs) == 0
+}
+
+// Errors returns the contained set of errors in sorted order, by plugin ref,
+// then old name, then new name, then documentation URL
+func (e *PluginDeprecations) Errors() []PluginDeprecation {
+	if e == nil {
+		return nil
+	}
+
+	if e.errs == nil {
+		return []PluginDeprecation{}
+	}
+
+	errs := make([]PluginDeprecation, 0, len(e.errs))
+	for err := range e.errs {
+		errs = append(errs, err)
+	}
+
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].pluginRef != errs[j].pluginRef {
+			return errs[i].pluginRef < errs[j].pluginRef
+		}
+		return errs[i].DeprecatedNameError.less(errs[j].DeprecatedNameError)
+	})
+
+	return errs
+}
+
+// Error returns each contained error on a new line
+func (e *PluginDeprecations) Error() string {
+	builder := strings.Builder{}
+	for i, err := range e.Errors() {
+		_, _ = builder.WriteString(err.Error())
+		if i < len(e.errs)-1 {
+			_, _ = builder.WriteRune('\n')
+		}
+	}
+	return builder.String()
+}
+
+// Append adds PluginDeprecation to the contained set and returns the receiver.
+// Like DeprecatedNameErrors.Append, this supports appending to nil, so should
+// be used just like the builtin `append` function.
+func (e *PluginDeprecations) Append(errs ...PluginDeprecation) *PluginDeprecations {
+	if e == nil {
+		e = &PluginDeprecations{errs: map[PluginDeprecation]unit{}}
+	} else if e.errs == nil {
+		e.errs = map[PluginDeprecation]unit{}
+	}
+
+	for _, err := range errs {
+		e.errs[err] = unit{}
+	}
+
+	return e
+}
+
+// Is returns true if and only if a error that is wrapped in target
+// contains the same set of PluginDeprecation as the receiver.
+func (e *PluginDeprecations) Is(target error) bool {
+	if e == nil {
+		return target == nil
+	}
+
+	var targetErr *PluginDeprecations
+	if !errors.As(target, &targetErr) {
+		return false
+	}
+
+	if len(e.errs) != len(targetErr.errs) {
+		return false
+	}
+
+	for err := range e.errs {
+		if _, exists := targetErr.errs[err]; !exists {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PluginDeprecation is a DeprecatedNameError along with a reference to the
+// plugin (e.g. its name or location) whose configuration used the deprecated
+// name.
+type PluginDeprecation struct {
+	DeprecatedNameError
+	pluginRef string
+}
+
+func NewPluginDeprecation(pluginRef string, err DeprecatedNameError) PluginDeprecation {
+	return PluginDeprecation{DeprecatedNameError: err, pluginRef: pluginRef}
+}
+
+// PluginRef returns the reference to the plugin that used the deprecated name
+func (e *PluginDeprecation) PluginRef() string {
+	return e.pluginRef
+}
+
+func (e *PluginDeprecation) Error() string {
+	return fmt.Sprintf("     plugin: %q\n%s", e.pluginRef, e.DeprecatedNameError.Error())
+}
+
+func (e *PluginDeprecation) Is(target error) bool {
+	if e == nil {
+		return target == nil
+	}
+
+	var targetErr *PluginDeprecation
+	if !errors.As(target, &targetErr) {
+		return false
+	}
+
+	return e.pluginRef == targetErr.pluginRef && e.DeprecatedNameError.Is(&targetErr.DeprecatedNameError)
 }
@@ -2,9 +2,12 @@ package plugin
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func cyclicPermute[T any](arr []T) {
@@ -113,3 +116,201 @@ func TestDeprecatedNameErrorsOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestPluginDeprecationsOrder(t *testing.T) {
+	t.Parallel()
+
+	var errs *PluginDeprecations
+	errs = errs.Append(
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("c", "d")),
+		NewPluginDeprecation("artifacts#v2", NewDeprecatedNameError("e", "f")),
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("a", "b")),
+		NewPluginDeprecation("artifacts#v2", NewDeprecatedNameError("a", "b")),
+	)
+
+	want := []PluginDeprecation{
+		{DeprecatedNameError: DeprecatedNameError{old: "a", new: "b"}, pluginRef: "artifacts#v2"},
+		{DeprecatedNameError: DeprecatedNameError{old: "e", new: "f"}, pluginRef: "artifacts#v2"},
+		{DeprecatedNameError: DeprecatedNameError{old: "a", new: "b"}, pluginRef: "docker#v1"},
+		{DeprecatedNameError: DeprecatedNameError{old: "c", new: "d"}, pluginRef: "docker#v1"},
+	}
+
+	got := errs.Errors()
+	if len(got) != len(want) {
+		t.Fatalf("errs.Errors() = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("errs.Errors()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPluginDeprecationsIs(t *testing.T) {
+	t.Parallel()
+
+	var errs1, errs2, errs3 *PluginDeprecations
+	errs1 = errs1.Append(
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("a", "b")),
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("c", "d")),
+	)
+
+	// Same set, different order, with a duplicate.
+	errs2 = errs2.Append(
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("c", "d")),
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("a", "b")),
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("a", "b")),
+	)
+	if !errors.Is(errs1, errs2) {
+		t.Errorf("expected PluginDeprecations Is() to not be sensitive to order or duplicates")
+	}
+
+	// Same names, but one from a different plugin.
+	errs3 = errs3.Append(
+		NewPluginDeprecation("docker#v1", NewDeprecatedNameError("a", "b")),
+		NewPluginDeprecation("docker#v2", NewDeprecatedNameError("c", "d")),
+	)
+	if errors.Is(errs1, errs3) {
+		t.Errorf("expected PluginDeprecations Is() to be sensitive to the plugin ref")
+	}
+
+	pd := NewPluginDeprecation("docker#v1", NewDeprecatedNameError("a", "b"))
+	if other := NewPluginDeprecation("docker#v2", NewDeprecatedNameError("a", "b")); errors.Is(&pd, &other) {
+		t.Errorf("expected PluginDeprecation Is() to be sensitive to the plugin ref")
+	}
+	if same := NewPluginDeprecation("docker#v1", NewDeprecatedNameError("a", "b")); !errors.Is(&pd, &same) {
+		t.Errorf("expected PluginDeprecation Is() to be true for equal deprecations")
+	}
+}
+
+func TestDeprecatedNameErrorDocURL(t *testing.T) {
+	t.Parallel()
+
+	const guide = "https://buildkite.com/docs/agent/v3/migrating"
+	plain := NewDeprecatedNameError("BUILDKITE_PLUGIN_FOO_TOKEN_", "BUILDKITE_PLUGIN_FOO_TOKEN")
+	linked := NewDeprecatedNameErrorWithDocURL("BUILDKITE_PLUGIN_FOO_TOKEN_", "BUILDKITE_PLUGIN_FOO_TOKEN", guide)
+	otherLink := NewDeprecatedNameErrorWithDocURL("BUILDKITE_PLUGIN_FOO_TOKEN_", "BUILDKITE_PLUGIN_FOO_TOKEN", guide+"#tokens")
+
+	if got, want := plain.Error(), " deprecated: \"BUILDKITE_PLUGIN_FOO_TOKEN_\"\nreplacement: \"BUILDKITE_PLUGIN_FOO_TOKEN\"\n"; got != want {
+		t.Errorf("plain.Error() = %q, want %q", got, want)
+	}
+	if got, want := linked.Error(), plain.Error()+"        see: "+guide+"\n"; got != want {
+		t.Errorf("linked.Error() = %q, want %q", got, want)
+	}
+
+	for _, test := range []struct {
+		name       string
+		err, other DeprecatedNameError
+		want       bool
+	}{
+		{name: "same URL", err: linked, other: NewDeprecatedNameErrorWithDocURL("BUILDKITE_PLUGIN_FOO_TOKEN_", "BUILDKITE_PLUGIN_FOO_TOKEN", guide), want: true},
+		{name: "no URLs", err: plain, other: NewDeprecatedNameError("BUILDKITE_PLUGIN_FOO_TOKEN_", "BUILDKITE_PLUGIN_FOO_TOKEN"), want: true},
+		{name: "different URLs", err: linked, other: otherLink, want: false},
+		{name: "only one URL", err: linked, other: plain, want: false},
+	} {
+		if got := errors.Is(&test.err, &test.other); got != test.want {
+			t.Errorf("%s: errors.Is(%q, %q) = %t, want %t", test.name, test.err.Error(), test.other.Error(), got, test.want)
+		}
+	}
+
+	// The same deprecation with different URLs is kept twice, in order.
+	var errs *DeprecatedNameErrors
+	errs = errs.Append(otherLink, linked, linked)
+	if diff := cmp.Diff(errs.Error(), linked.Error()+"\n"+otherLink.Error()); diff != "" {
+		t.Errorf("errs.Error() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestDeprecatedNameErrorsGroupByReplacement(t *testing.T) {
+	t.Parallel()
+
+	var errs *DeprecatedNameErrors
+	errs = errs.Append(
+		NewDeprecatedNameError("BUILDKITE_PLUGIN_FOO_TOKEN_", "BUILDKITE_PLUGIN_FOO_TOKEN"),
+		NewDeprecatedNameError("BUILDKITE_PLUGIN_FOO__TOKEN", "BUILDKITE_PLUGIN_FOO_TOKEN"),
+		NewDeprecatedNameError("BUILDKITE_PLUGIN__FOO_TOKEN", "BUILDKITE_PLUGIN_FOO_TOKEN"),
+		NewDeprecatedNameError("BUILDKITE_PLUGIN_BAR__KEY", "BUILDKITE_PLUGIN_BAR_KEY"),
+	)
+
+	want := map[string][]string{
+		"BUILDKITE_PLUGIN_FOO_TOKEN": {
+			"BUILDKITE_PLUGIN_FOO_TOKEN_",
+			"BUILDKITE_PLUGIN_FOO__TOKEN",
+			"BUILDKITE_PLUGIN__FOO_TOKEN",
+		},
+		"BUILDKITE_PLUGIN_BAR_KEY": {
+			"BUILDKITE_PLUGIN_BAR__KEY",
+		},
+	}
+	if diff := cmp.Diff(errs.GroupByReplacement(), want); diff != "" {
+		t.Errorf("errs.GroupByReplacement() diff (-got +want):\n%s", diff)
+	}
+
+	var empty *DeprecatedNameErrors
+	if got := empty.GroupByReplacement(); len(got) != 0 {
+		t.Errorf("empty.GroupByReplacement() = %v, want empty map", got)
+	}
+}
+
+// deprecatedNamePairs decodes each byte of b as an old/new name pair, from a
+// small alphabet so that fuzzed inputs often share pairs.
+func deprecatedNamePairs(b []byte) []DeprecatedNameError {
+	pairs := make([]DeprecatedNameError, 0, len(b))
+	for _, c := range b {
+		oldName := "BUILDKITE_PLUGIN_" + string(rune('A'+c&7)) + "_"
+		newName := "BUILDKITE_PLUGIN_" + string(rune('A'+(c>>3)&7))
+		pairs = append(pairs, NewDeprecatedNameError(oldName, newName))
+	}
+	return pairs
+}
+
+func FuzzDeprecatedNameErrorsIs(f *testing.F) {
+	f.Add([]byte{1, 2, 3}, []byte{3, 2, 1}) // equal, in a different order
+	f.Add([]byte{1, 2, 2, 1}, []byte{2, 1}) // equal, with duplicates
+	f.Add([]byte{1, 2}, []byte{1, 2, 3})    // subset
+	f.Add([]byte{1, 2, 3}, []byte{1, 2})    // superset
+	f.Add([]byte{1, 2}, []byte{9, 10})      // disjoint
+	f.Add([]byte{8}, []byte{1})             // same old name, different new
+	f.Add([]byte{}, []byte{})               // both empty
+	f.Add([]byte{}, []byte{0})              // one empty
+
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		pairsA, pairsB := deprecatedNamePairs(a), deprecatedNamePairs(b)
+
+		// Reference set comparison.
+		setA := map[DeprecatedNameError]bool{}
+		for _, p := range pairsA {
+			setA[p] = true
+		}
+		setB := map[DeprecatedNameError]bool{}
+		for _, p := range pairsB {
+			setB[p] = true
+		}
+		want := len(setA) == len(setB)
+		for p := range setA {
+			want = want && setB[p]
+		}
+
+		// Build b one pair at a time, in reverse, to vary the order and
+		// exercise repeated Appends.
+		errsA := (*DeprecatedNameErrors)(nil).Append(pairsA...)
+		var errsB *DeprecatedNameErrors
+		errsB = errsB.Append()
+		for i := len(pairsB) - 1; i >= 0; i-- {
+			errsB = errsB.Append(pairsB[i])
+		}
+
+		if got := errsA.Is(errsB); got != want {
+			t.Errorf("errsA.Is(errsB) = %t, want %t\nerrsA:\n%v\nerrsB:\n%v", got, want, errsA, errsB)
+		}
+		if got := errsB.Is(errsA); got != want {
+			t.Errorf("errsB.Is(errsA) = %t, want %t", got, want)
+		}
+
+		// errors.Is finds errsB wrapped deep in a chain.
+		wrapped := fmt.Errorf("outer: %w", fmt.Errorf("middle: %w", fmt.Errorf("inner: %w", errsB)))
+		if got := errors.Is(wrapped, errsA); got != want {
+			t.Errorf("errors.Is(wrapped errsB, errsA) = %t, want %t", got, want)
+		}
+	})
+}
@@ -363,7 +363,7 @@ func (b *Bootstrap) runUnwrappedHook(ctx context.Context, hookName string, hookC
 }
 
 func (b *Bootstrap) runWrappedShellScriptHook(ctx context.Context, hookName string, hookCfg HookConfig) error {
-	redactors := b.setupRedactors()
+	redactors := b.setupRedactors(ctx)
 	defer redactors.Flush()
 
 	script, err := hook.NewScriptWrapper(hook.WithHookPath(hookCfg.Path))
@@ -1889,7 +1889,7 @@ func (b *Bootstrap) defaultCommandPhase(ctx context.Context) error {
 		cmdToExec = fmt.Sprintf("trap 'kill -- $$' INT TERM QUIT; %s", cmdToExec)
 	}
 
-	redactors := b.setupRedactors()
+	redactors := b.setupRedactors(ctx)
 	defer redactors.Flush()
 
 	var cmd []string
@@ -2073,13 +2073,16 @@ func (b *Bootstrap) ignoredEnv() []string {
 	return ignored
 }
 
+// patternEscaper escapes the special characters of path.Match patterns.
+var patternEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
 // setupRedactors wraps shell output and logging in Redactor if any redaction
 // is necessary based on RedactedVars configuration and the existence of
 // matching environment vars.
 // redactor.Mux (possibly empty) is returned so the caller can `defer redactor.Flush()`
-func (b *Bootstrap) setupRedactors() redactor.Mux {
-	valuesToRedact := redactor.ValuesToRedact(b.shell, b.Config.RedactedVars, b.shell.Env.Dump())
-	if len(valuesToRedact) == 0 {
+func (b *Bootstrap) setupRedactors(ctx context.Context) redactor.Mux {
+	varsToRedact := redactor.VarsToRedact(b.shell, b.Config.RedactedVars, b.shell.Env.Dump())
+	if len(varsToRedact) == 0 {
 		return nil
 	}
 
@@ -2087,35 +2090,37 @@ func (b *Bootstrap) setupRedactors() redactor.Mux {
 		b.shell.Commentf("Enabling output redaction for values from environment variables matching: %v", b.Config.RedactedVars)
 	}
 
-	var mux redactor.Mux
-
-	// If the shell Writer is already a Redactor, reset the values to redact.
-	if rdc, ok := b.shell.Writer.(*redactor.Redactor); ok {
-		rdc.Reset(valuesToRedact)
-		mux = append(mux, rdc)
-	} else {
-		rdc := redactor.New(b.shell.Writer, "[REDACTED]", valuesToRedact)
-		b.shell.Writer = rdc
-		mux = append(mux, rdc)
+	// If the shell Writer is already a Redactor, it is reset with the values
+	// to redact, and likewise the Writer of the shell.Logger (if it is a
+	// WriterLogger). Only the variables to redact are passed on, each with a
+	// pattern matching just its name, so warnings about the others (such as
+	// short values) aren't repeated.
+	patterns := make([]string, 0, len(varsToRedact))
+	for name := range varsToRedact {
+		patterns = append(patterns, patternEscaper.Replace(name))
+	}
+	dsts := []io.Writer{b.shell.Writer}
+	logger, _ := b.shell.Logger.(*shell.WriterLogger)
+	var loggerRedactor *redactor.Redactor
+	if logger != nil {
+		loggerRedactor, _ = logger.Writer.(*redactor.Redactor)
+	}
+	if loggerRedactor != nil {
+		dsts = append(dsts, loggerRedactor)
 	}
 
-	// If the shell.Logger is already a redacted WriterLogger, reset the values to redact.
-	// (maybe there's a better way to do two levels of type assertion? ...
-	// shell.Logger may be a WriterLogger, and its Writer may be a Redactor)
-	var shellWriterLogger *shell.WriterLogger
-	var shellLoggerRedactor *redactor.Redactor
-	if logger, ok := b.shell.Logger.(*shell.WriterLogger); ok {
-		shellWriterLogger = logger
-		if redactor, ok := logger.Writer.(*redactor.Redactor); ok {
-			shellLoggerRedactor = redactor
-		}
+	mux, err := redactor.SetupRedactors(ctx, b.shell, patterns, varsToRedact, "[REDACTED]", dsts...)
+	if err != nil {
+		// The context is done, so whatever was going to be run won't be.
+		return nil
 	}
-	if rdc := shellLoggerRedactor; rdc != nil {
-		rdc.Reset(valuesToRedact)
-		mux = append(mux, rdc)
-	} else if shellWriterLogger != nil {
-		rdc := redactor.New(b.shell.Writer, "[REDACTED]", valuesToRedact)
-		shellWriterLogger.Writer = rdc
+	b.shell.Writer = mux[0]
+
+	// Otherwise, the shell.Logger writes through another redactor, with the
+	// same values to redact, to the shell Writer.
+	if logger != nil && loggerRedactor == nil {
+		rdc := mux[0].Clone(b.shell.Writer)
+		logger.Writer = rdc
 		mux = append(mux, rdc)
 	}
 
@@ -2,9 +2,11 @@ package bootstrap
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/buildkite/agent/v3/env"
 	"github.com/buildkite/agent/v3/internal/redactor"
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/google/go-cmp/cmp"
@@ -123,3 +125,60 @@ func TestStartTracing_Datadog(t *testing.T) {
 	assert.Equal(t, spanImpl.Span, opentracing.SpanFromContext(ctx))
 	stopper()
 }
+
+func TestSetupRedactors(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+	logger := &shell.WriterLogger{Writer: &out}
+	b := New(Config{RedactedVars: []string{"*_TOKEN"}})
+	b.shell = shell.NewTestShell(t)
+	b.shell.Writer = &out
+	b.shell.Logger = logger
+	b.shell.Env = env.FromMap(map[string]string{"BUILDKITE_PIPELINE": "unit-test"})
+
+	// With nothing to redact, nothing is wrapped.
+	if got := b.setupRedactors(context.Background()); got != nil {
+		t.Errorf("b.setupRedactors() with nothing to redact = %v, want nil", got)
+	}
+	if b.shell.Writer != &out || logger.Writer != &out {
+		t.Errorf("b.setupRedactors() with nothing to redact replaced the shell or logger Writer")
+	}
+
+	// The name of a variable to redact can contain pattern characters.
+	b.shell.Env.Set("GITHUB[1]_TOKEN", "ghp_abcdef")
+	mux := b.setupRedactors(context.Background())
+	if len(mux) != 2 {
+		t.Fatalf("len(b.setupRedactors()) = %d, want 2", len(mux))
+	}
+	shellRedactor, ok := b.shell.Writer.(*redactor.Redactor)
+	if !ok {
+		t.Fatalf("after b.setupRedactors(), b.shell.Writer = %T, want *redactor.Redactor", b.shell.Writer)
+	}
+	if _, ok := logger.Writer.(*redactor.Redactor); !ok {
+		t.Fatalf("after b.setupRedactors(), logger.Writer = %T, want *redactor.Redactor", logger.Writer)
+	}
+
+	// Setting up again resets the same redactors.
+	b.shell.Env.Set("NPM_TOKEN", "npm_123456")
+	again := b.setupRedactors(context.Background())
+	if len(again) != 2 || again[0] != mux[0] || again[1] != mux[1] {
+		t.Errorf("b.setupRedactors() again = %v, want the same redactors %v", again, mux)
+	}
+
+	logger.Printf("logged ghp_abcdef npm_123456")
+	if err := mux.Flush(); err != nil {
+		t.Fatalf("mux.Flush() = %v", err)
+	}
+	if _, err := shellRedactor.Write([]byte("written ghp_abcdef npm_123456\n")); err != nil {
+		t.Fatalf("shellRedactor.Write() = %v", err)
+	}
+	if err := shellRedactor.Flush(); err != nil {
+		t.Fatalf("shellRedactor.Flush() = %v", err)
+	}
+
+	want := "logged [REDACTED] [REDACTED]\nwritten [REDACTED] [REDACTED]\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
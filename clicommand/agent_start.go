@@ -23,6 +23,7 @@ import (
 	"github.com/buildkite/agent/v3/experiments"
 	"github.com/buildkite/agent/v3/hook"
 	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/internal/redactor"
 	"github.com/buildkite/agent/v3/internal/utils"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/metrics"
@@ -761,6 +762,12 @@ var AgentStartCommand = cli.Command{
 			l.Fatal("The given tracing backend %q is not supported. Valid backends are: %q", cfg.TracingBackend, maps.Keys(tracetools.ValidTracingBackends))
 		}
 
+		// Bad redacted-vars patterns would otherwise only be warned about in
+		// each job's log, while the variables they were meant to cover leak.
+		if err := redactor.ValidatePatterns(cfg.RedactedVars); err != nil {
+			l.Fatal("Invalid redacted-vars: %v", err)
+		}
+
 		if experiments.IsEnabled(experiments.AgentAPI) {
 			shutdown := runAgentAPI(ctx, l, cfg.SocketsPath)
 			defer shutdown()
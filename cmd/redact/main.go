@@ -0,0 +1,131 @@
+// Command redact copies stdin to stdout, redacting secrets along the way. It
+// is the agent's log redactor packaged as a filter, for scrubbing the output
+// of tools run outside a job, or logs collected by other means:
+//
+//	SECRET_TOKEN=... some-tool | redact --pattern '*_TOKEN' --secret-file '/run/secrets/*'
+//
+// Secrets are the values of environment variables whose names match a
+// --pattern, and the contents of files matching a --secret-file glob. Output
+// is written as it is redacted, except for the few bytes that could be the
+// start of a secret, which are held until the rest of the input shows
+// whether they are.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/redactor"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Environ()))
+}
+
+// stringsFlag is a flag that can be given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// run runs the command with the given arguments (excluding the command name),
+// standard streams and environment, and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer, environ []string) int {
+	var patterns, secretFiles stringsFlag
+	fs := flag.NewFlagSet("redact", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&patterns, "pattern", "redact the values of environment variables whose names match this glob (repeatable)")
+	fs.Var(&secretFiles, "secret-file", "redact the contents of files matching this glob, one secret per file (repeatable)")
+	subst := fs.String("subst", "[REDACTED]", "replace secrets with this")
+	minLength := fs.Int("min-length", redactor.RedactLengthMin, fmt.Sprintf("ignore secrets shorter than this many bytes (at least %d)", redactor.RedactLengthMin))
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: redact [--pattern GLOB]... [--secret-file GLOB]... [--subst STRING] [--min-length N]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Copies stdin to stdout, redacting secrets.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "redact: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return 2
+	}
+	// The secrets are found with the same functions the agent uses, which
+	// never return secrets shorter than RedactLengthMin.
+	if *minLength < redactor.RedactLengthMin {
+		fmt.Fprintf(stderr, "redact: --min-length must be at least %d\n", redactor.RedactLengthMin)
+		return 2
+	}
+
+	logger := &shell.WriterLogger{Writer: stderr}
+	needles, err := secrets(logger, patterns, secretFiles, env.FromSlice(environ).Dump(), *minLength)
+	if err != nil {
+		fmt.Fprintf(stderr, "redact: %v\n", err)
+		return 1
+	}
+	if len(needles) == 0 {
+		logger.Warningf("No secrets to redact")
+	}
+
+	r := redactor.New(stdout, *subst, needles, redactor.WithLogger(logger))
+	if _, err := io.Copy(r, stdin); err != nil {
+		// Write out what was read, redacted, before giving up.
+		r.Flush()
+		fmt.Fprintf(stderr, "redact: %v\n", err)
+		return 1
+	}
+	if err := r.Flush(); err != nil {
+		fmt.Fprintf(stderr, "redact: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// secrets returns the values of the variables in environment matching
+// patterns, and the contents of the files matching secretFiles, that are at
+// least minLength bytes long.
+func secrets(logger shell.Logger, patterns, secretFiles []string, environment map[string]string, minLength int) ([]string, error) {
+	if err := redactor.ValidatePatterns(patterns); err != nil {
+		return nil, err
+	}
+
+	var needles []string
+	seen := make(map[string]bool)
+	add := func(s string) {
+		if len(s) >= minLength && !seen[s] {
+			seen[s] = true
+			needles = append(needles, s)
+		}
+	}
+
+	for _, v := range redactor.VarsToRedact(logger, patterns, environment) {
+		add(v)
+	}
+	for _, glob := range secretFiles {
+		ss, err := redactor.NeedlesFromFiles(logger, glob)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range ss {
+			add(s)
+		}
+	}
+	return needles, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db-password"), []byte("correct horse battery staple\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() = %v", err)
+	}
+	environ := []string{
+		"API_TOKEN=llamas-are-great",
+		"SHORT_TOKEN=abc",
+		"HOME=/home/buildkite",
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "pattern",
+			args: []string{"--pattern", "*_TOKEN"},
+			want: "token [REDACTED], password correct horse battery staple, home /home/buildkite, short abc\n",
+		},
+		{
+			name: "pattern and secret file",
+			args: []string{"--pattern", "*_TOKEN", "--secret-file", filepath.Join(dir, "*")},
+			want: "token [REDACTED], password [REDACTED], home /home/buildkite, short abc\n",
+		},
+		{
+			name: "repeated pattern",
+			args: []string{"--pattern", "API_*", "--pattern", "HOME", "--subst", "***"},
+			want: "token ***, password correct horse battery staple, home ***, short abc\n",
+		},
+		{
+			name: "min length",
+			args: []string{"--pattern", "*", "--secret-file", filepath.Join(dir, "*"), "--min-length", "20"},
+			want: "token llamas-are-great, password [REDACTED], home /home/buildkite, short abc\n",
+		},
+	}
+
+	input := "token llamas-are-great, password correct horse battery staple, home /home/buildkite, short abc\n"
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stdout, stderr strings.Builder
+			if code := run(test.args, strings.NewReader(input), &stdout, &stderr, environ); code != 0 {
+				t.Fatalf("run(%q) = %d, want 0; stderr:\n%s", test.args, code, stderr.String())
+			}
+			if got := stdout.String(); got != test.want {
+				t.Errorf("run(%q) stdout = %q, want %q", test.args, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactBadArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{args: []string{"--pattern", "[*"}, want: 1},
+		{args: []string{"--secret-file", "[*"}, want: 1},
+		{args: []string{"--min-length", "1"}, want: 2},
+		{args: []string{"--no-such-flag"}, want: 2},
+		{args: []string{"extra"}, want: 2},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr strings.Builder
+		if got := run(test.args, strings.NewReader("input\n"), &stdout, &stderr, nil); got != test.want {
+			t.Errorf("run(%q) = %d, want %d", test.args, got, test.want)
+		}
+		if stdout.Len() != 0 {
+			t.Errorf("run(%q) stdout = %q, want nothing", test.args, stdout.String())
+		}
+	}
+}
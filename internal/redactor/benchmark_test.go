@@ -0,0 +1,191 @@
+package redactor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// benchmarkNeedles returns count pseudo-random needles of length size.
+func benchmarkNeedles(count, size int) []string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	rng := rand.New(rand.NewSource(int64(count*1000 + size)))
+	needles := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		b := make([]byte, size)
+		for j := range b {
+			b[j] = alphabet[rng.Intn(len(alphabet))]
+		}
+		needles = append(needles, string(b))
+	}
+	return needles
+}
+
+// benchmarkInput returns size bytes of log-like text, with one of the needles
+// (if any) inserted every few lines.
+func benchmarkInput(size int, needles []string) []byte {
+	rng := rand.New(rand.NewSource(int64(size)))
+	var sb strings.Builder
+	for line := 0; sb.Len() < size; line++ {
+		sb.WriteString(bigLipsum[rng.Intn(len(bigLipsum)/2):][:80])
+		if len(needles) > 0 && line%4 == 0 {
+			sb.WriteString(needles[rng.Intn(len(needles))])
+		}
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String()[:size])
+}
+
+// writeChunked writes input to w in chunks of size chunk (or all at once if
+// chunk is 0).
+func writeChunked(w io.Writer, input []byte, chunk int) {
+	if chunk == 0 {
+		w.Write(input)
+		return
+	}
+	for len(input) > 0 {
+		n := chunk
+		if n > len(input) {
+			n = len(input)
+		}
+		w.Write(input[:n])
+		input = input[n:]
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	for _, count := range []int{0, 1, 10, 100, 1000} {
+		for _, size := range []int{8, 40} {
+			needles := benchmarkNeedles(count, size)
+			for _, inputSize := range []int{1 << 10, 64 << 10} {
+				input := benchmarkInput(inputSize, needles)
+				for _, chunk := range []int{64, 4096, 0} {
+					name := fmt.Sprintf("needles=%d/needle_len=%d/input=%d/chunk=%d", count, size, inputSize, chunk)
+					b.Run(name, func(b *testing.B) {
+						r := New(io.Discard, "[REDACTED]", needles)
+						b.SetBytes(int64(len(input)))
+						b.ReportAllocs()
+						b.ResetTimer()
+						for n := 0; n < b.N; n++ {
+							writeChunked(r, input, chunk)
+						}
+						r.Flush()
+					})
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkWriteDegenerate benchmarks the pathological case, where every byte
+// of input begins a new partial match of every needle, and the partial
+// matches persist until the end of each needle.
+func BenchmarkWriteDegenerate(b *testing.B) {
+	for _, count := range []int{1, 10, 100} {
+		needles := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			needles = append(needles, strings.Repeat("a", 32)+fmt.Sprint(i))
+		}
+		input := []byte(strings.Repeat("a", 4096))
+
+		b.Run(fmt.Sprintf("needles=%d", count), func(b *testing.B) {
+			r := New(io.Discard, "[REDACTED]", needles)
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				r.Write(input)
+			}
+			r.Flush()
+		})
+	}
+}
+
+func BenchmarkFlush(b *testing.B) {
+	needles := benchmarkNeedles(100, 40)
+	// End each write with the start of a needle, so Flush has something to
+	// write out.
+	input := append(benchmarkInput(4096, needles), needles[0][:20]...)
+
+	r := New(io.Discard, "[REDACTED]", needles)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		r.Write(input)
+		r.Flush()
+	}
+}
+
+// countingWriter counts calls to Write.
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return len(b), nil
+}
+
+func BenchmarkWriteBufferedOutput(b *testing.B) {
+	needles := benchmarkNeedles(10, 16)
+	input := benchmarkInput(64*1024, needles)
+
+	for _, size := range []int{0, 4096, 65536} {
+		size := size
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			var dst countingWriter
+			redactor := New(&dst, "[REDACTED]", needles, WithBufferedOutput(size))
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				writeChunked(redactor, input, 4096)
+				redactor.Flush()
+			}
+			b.ReportMetric(float64(dst.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func BenchmarkWriteAfterGrowingReset(b *testing.B) {
+	// All the needles share a prefix, so the input makes every one of them a
+	// partial match at once.
+	const prefix = "sharedprefix-"
+	needles := benchmarkNeedles(5000, 20)
+	for i := range needles {
+		needles[i] = prefix + needles[i]
+	}
+	input := []byte("some output " + prefix + "notasecret\n")
+
+	redactor := New(io.Discard, "[REDACTED]", needles[:10])
+	redactor.Reset(needles)
+
+	write := func() {
+		redactor.Write(input)
+		redactor.Flush()
+	}
+	// Grow buf with input that matches no needles, so that only the match
+	// slices could need to grow while writing input that does. (AllocsPerRun
+	// can't be used, since its warm-up run would hide any growth.)
+	redactor.Write(bytes.Repeat([]byte{'.'}, len(input)))
+	redactor.Flush()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	write()
+	runtime.ReadMemStats(&after)
+	if allocs := after.Mallocs - before.Mallocs; allocs != 0 {
+		b.Errorf("after Reset from 10 to %d needles, Write and Flush allocated %d times, want 0", len(needles), allocs)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		write()
+	}
+}
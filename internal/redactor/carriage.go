@@ -0,0 +1,115 @@
+package redactor
+
+import "io"
+
+// crMaxLine is the longest line crCollapser holds before writing it out
+// regardless of carriage returns.
+const crMaxLine = 65536
+
+// crCollapser is a writer that removes text overwritten by a carriage return.
+// When a terminal prints a carriage return (not followed by a line feed), the
+// cursor returns to the start of the line, and subsequent text overwrites the
+// line. A sink that doesn't interpret carriage returns would otherwise see the
+// overwritten text. crCollapser holds each line until it ends, and discards
+// the line so far whenever it sees a bare carriage return.
+//
+// Unlike a terminal, which would leave the end of a long line visible after
+// it is overwritten by a shorter one, crCollapser discards all of it.
+type crCollapser struct {
+	lineOutput
+
+	// The current line.
+	line []byte
+
+	// Whether the last byte was a carriage return, which could either be bare
+	// or the start of "\r\n".
+	pendingCR bool
+}
+
+// Write writes b, collapsing carriage returns. Complete lines are written to
+// dst; the rest is held until the line is complete or flush is called.
+func (w *crCollapser) Write(b []byte) (int, error) {
+	if err := w.retry(); err != nil {
+		return 0, err
+	}
+	for n, c := range b {
+		if w.pendingCR {
+			w.pendingCR = false
+			if c != '\n' {
+				// A bare carriage return. Discard the line so far, which will
+				// be overwritten.
+				w.line = w.line[:0]
+			} else {
+				w.line = append(w.line, '\r')
+			}
+		}
+
+		if c == '\r' {
+			w.pendingCR = true
+			continue
+		}
+
+		w.line = append(w.line, c)
+		if c == '\n' || len(w.line) >= crMaxLine {
+			if err := w.writeLine(); err != nil {
+				// c is part of the line, which is kept to be retried.
+				return n + 1, err
+			}
+		}
+	}
+	return len(b), nil
+}
+
+// flush writes out the current line, including any trailing carriage return.
+func (w *crCollapser) flush() error {
+	if w.pendingCR {
+		w.pendingCR = false
+		w.line = append(w.line, '\r')
+	}
+	return w.writeLine()
+}
+
+// writeLine writes the current line to dst, and starts a new one.
+func (w *crCollapser) writeLine() error {
+	if len(w.line) == 0 {
+		return w.retry()
+	}
+	err := w.write(w.line)
+	w.line = w.line[:0]
+	return err
+}
+
+// lineOutput writes the lines held by crCollapser and lineDropper to dst. If
+// dst fails, the output it didn't accept is kept, and written before any
+// more, so that the line is neither lost nor written twice when the Redactor
+// retries (see Redactor.Write).
+type lineOutput struct {
+	dst io.Writer
+
+	// Output dst didn't accept.
+	unwritten []byte
+}
+
+// write writes b to dst, after any output kept from an earlier failure. If
+// that fails, the rest is kept.
+func (o *lineOutput) write(b []byte) error {
+	if err := o.retry(); err != nil {
+		o.unwritten = append(o.unwritten, b...)
+		return err
+	}
+	n, err := o.dst.Write(b)
+	if err != nil {
+		o.unwritten = append(o.unwritten, b[n:]...)
+	}
+	return err
+}
+
+// retry writes any output kept from an earlier failure.
+func (o *lineOutput) retry() error {
+	if len(o.unwritten) == 0 {
+		return nil
+	}
+	n, err := o.dst.Write(o.unwritten)
+	o.unwritten = o.unwritten[:copy(o.unwritten, o.unwritten[n:])]
+	return err
+}
@@ -0,0 +1,84 @@
+package redactor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRedactorCarriageReturn(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc  string
+		opts  []Option
+		input string
+		want  string
+	}{
+		{
+			desc:  "Secrets match around carriage returns",
+			input: "hunter2\rtoken: hunter2\r\ndone\n",
+			want:  "[REDACTED]\rtoken: [REDACTED]\r\ndone\n",
+		},
+		{
+			desc:  "Secret overwritten, without collapsing",
+			input: "password: sekrit99\r                  \rdone\n",
+			want:  "password: sekrit99\r                  \rdone\n",
+		},
+		{
+			desc:  "Secret overwritten, with collapsing",
+			opts:  []Option{WithCarriageReturnCollapse()},
+			input: "password: sekrit99\r                  \rdone\n",
+			want:  "done\n",
+		},
+		{
+			desc:  "Progress bar with a redacted secret",
+			opts:  []Option{WithCarriageReturnCollapse()},
+			input: "hunter2 10%\rhunter2 50%\rhunter2 100%\nnext line\n",
+			want:  "[REDACTED] 100%\nnext line\n",
+		},
+		{
+			desc:  "CRLF line endings are kept",
+			opts:  []Option{WithCarriageReturnCollapse()},
+			input: "one\r\ntwo\r\nthree\r",
+			want:  "one\r\ntwo\r\nthree\r",
+		},
+		{
+			desc:  "Unterminated line is flushed",
+			opts:  []Option{WithCarriageReturnCollapse()},
+			input: "one\rtwo",
+			want:  "two",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run("One write;"+test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := New(&buf, "[REDACTED]", []string{"hunter2"}, test.opts...)
+			fmt.Fprint(redactor, test.input)
+			redactor.Flush()
+
+			if got, want := buf.String(), test.want; got != want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+			}
+		})
+
+		t.Run("Many writes;"+test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := New(&buf, "[REDACTED]", []string{"hunter2"}, test.opts...)
+			for _, c := range []byte(test.input) {
+				redactor.Write([]byte{c})
+			}
+			redactor.Flush()
+
+			if got, want := buf.String(), test.want; got != want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package redactor
+
+import (
+	"path"
+	"strings"
+)
+
+// ContentTypes decides which content (such as HTTP bodies or artifacts) to
+// redact by its media type, so that binary content, in which redaction is
+// meaningless and would corrupt it, is left alone.
+//
+// Allow and Deny are lists of media type patterns, matched with path.Match,
+// such as "text/*", "application/json", or "application/*+json". A media type
+// matching Allow is redacted, otherwise one matching Deny is not, and any
+// other media type (including an empty one) is redacted, since an unknown
+// type could be text. So Allow can make exceptions to Deny, and the zero
+// ContentTypes redacts everything.
+type ContentTypes struct {
+	Allow []string
+	Deny  []string
+}
+
+// DefaultContentTypes redacts text, JSON, XML, and YAML, and not images, audio,
+// video, fonts, archives, compressed files, PDFs, or arbitrary binary data.
+var DefaultContentTypes = ContentTypes{
+	Allow: []string{
+		"text/*",
+		"application/json",
+		"application/*+json",
+		"application/x-ndjson",
+		"application/xml",
+		"application/*+xml",
+		"application/yaml",
+		"application/x-yaml",
+		"application/javascript",
+	},
+	Deny: []string{
+		"image/*",
+		"audio/*",
+		"video/*",
+		"font/*",
+		"application/octet-stream",
+		"application/gzip",
+		"application/x-gzip",
+		"application/zip",
+		"application/zstd",
+		"application/x-tar",
+		"application/x-bzip2",
+		"application/x-xz",
+		"application/x-7z-compressed",
+		"application/pdf",
+		"application/wasm",
+	},
+}
+
+// ShouldRedact reports whether content of the content type, such as the value
+// of a Content-Type header (parameters, such as the charset, are ignored),
+// should be redacted.
+func (c ContentTypes) ShouldRedact(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if matchesMediaType(c.Allow, mediaType) {
+		return true
+	}
+	return !matchesMediaType(c.Deny, mediaType)
+}
+
+// matchesMediaType reports whether the media type matches any of the
+// patterns. Malformed patterns match nothing.
+func matchesMediaType(patterns []string, mediaType string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package redactor
+
+import "testing"
+
+func TestContentTypesShouldRedact(t *testing.T) {
+	t.Parallel()
+
+	custom := ContentTypes{
+		Allow: []string{"application/json"},
+		Deny:  []string{"application/*", "text/csv"},
+	}
+	for _, test := range []struct {
+		types       ContentTypes
+		contentType string
+		want        bool
+	}{
+		{DefaultContentTypes, "text/plain", true},
+		{DefaultContentTypes, "text/html; charset=utf-8", true},
+		{DefaultContentTypes, "Text/Plain", true},
+		{DefaultContentTypes, "application/json", true},
+		{DefaultContentTypes, "application/vnd.api+json", true},
+		{DefaultContentTypes, "application/x-yaml", true},
+		{DefaultContentTypes, "image/png", false},
+		{DefaultContentTypes, "image/svg+xml", false},
+		{DefaultContentTypes, "application/octet-stream", false},
+		{DefaultContentTypes, "application/gzip", false},
+		{DefaultContentTypes, "application/x-unknown", true},
+		{DefaultContentTypes, "", true},
+		{custom, "application/json", true},
+		{custom, "application/xml", false},
+		{custom, "text/csv", false},
+		{custom, "text/plain", true},
+		{ContentTypes{}, "image/png", true},
+	} {
+		if got := test.types.ShouldRedact(test.contentType); got != test.want {
+			t.Errorf("%+v.ShouldRedact(%q) = %t, want %t", test.types, test.contentType, got, test.want)
+		}
+	}
+}
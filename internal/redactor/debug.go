@@ -0,0 +1,7 @@
+//go:build redactordebug
+
+package redactor
+
+// debugInvariants enables checks of internal invariants that are too costly
+// for production builds. See checkSortedByTo.
+const debugInvariants = true
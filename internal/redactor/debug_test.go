@@ -0,0 +1,67 @@
+//go:build redactordebug
+
+package redactor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCheckSortedByTo(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		desc      string
+		rs        []subrange
+		wantPanic bool
+	}{
+		{desc: "empty"},
+		{desc: "sorted", rs: []subrange{{from: 0, to: 3}, {from: 1, to: 3}, {from: 5, to: 8}}},
+		{desc: "sorted by to, not from", rs: []subrange{{from: 2, to: 4}, {from: 0, to: 6}}},
+		{desc: "unsorted", rs: []subrange{{from: 5, to: 8}, {from: 0, to: 3}}, wantPanic: true},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				if got := recover() != nil; got != test.wantPanic {
+					t.Errorf("checkSortedByTo(%v) panicked = %t, want %t", test.rs, got, test.wantPanic)
+				}
+			}()
+			checkSortedByTo(test.rs, "test")
+		})
+	}
+}
+
+func TestRedactorUnsortedMatchesPanic(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		desc string
+		call func(*Redactor)
+	}{
+		{desc: "writeChunk", call: func(r *Redactor) { r.Write([]byte("x")) }},
+		{desc: "flush", call: func(r *Redactor) { r.Flush() }},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := New(io.Discard, "[REDACTED]", []string{"secret1111"}, WithAdjacentMerge())
+			// Simulate a matching bug that completes matches out of order.
+			r.buf = append(r.buf, "0123456789"...)
+			r.completedMatches = append(r.completedMatches, subrange{from: 5, to: 8}, subrange{from: 0, to: 3})
+
+			defer func() {
+				got := fmt.Sprint(recover())
+				if !strings.Contains(got, test.desc+": completed matches not sorted") {
+					t.Errorf("recover() = %q, want a panic about unsorted matches in %s", got, test.desc)
+				}
+			}()
+			test.call(r)
+		})
+	}
+}
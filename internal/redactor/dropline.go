@@ -0,0 +1,60 @@
+package redactor
+
+// lineDropper is a writer that implements the DropLine policy: it holds each
+// line until it ends, and then writes it to dst, unless drop was called while
+// the line was being written, in which case it discards it.
+type lineDropper struct {
+	lineOutput
+
+	// The current line.
+	line []byte
+
+	// Whether the current line contains a secret.
+	dropping bool
+}
+
+// Write writes b. Complete lines are written to dst (or dropped); the rest is
+// held until the line is complete or flush is called.
+func (w *lineDropper) Write(b []byte) (int, error) {
+	if err := w.retry(); err != nil {
+		return 0, err
+	}
+	for n, c := range b {
+		w.line = append(w.line, c)
+		if c == '\n' || len(w.line) >= crMaxLine {
+			err := w.writeLine()
+			if c == '\n' {
+				w.dropping = false
+			}
+			if err != nil {
+				// c is part of the line, which is kept to be retried.
+				return n + 1, err
+			}
+		}
+	}
+	return len(b), nil
+}
+
+// drop causes the current line to be discarded.
+func (w *lineDropper) drop() {
+	w.dropping = true
+}
+
+// flush writes out the current line (unless it is dropped), and starts a new
+// one.
+func (w *lineDropper) flush() error {
+	err := w.writeLine()
+	w.dropping = false
+	return err
+}
+
+// writeLine writes the current line to dst, or discards it if it is dropped.
+func (w *lineDropper) writeLine() error {
+	if len(w.line) == 0 || w.dropping {
+		w.line = w.line[:0]
+		return w.retry()
+	}
+	err := w.write(w.line)
+	w.line = w.line[:0]
+	return err
+}
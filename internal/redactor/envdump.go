@@ -0,0 +1,202 @@
+package redactor
+
+import (
+	"bytes"
+	"io"
+)
+
+// EnvDumpRedactor redacts the output of dumping an environment (such as `env`
+// or `export -p`), which has one KEY=VALUE assignment per line. Rather than
+// searching for secret values anywhere in the output, it replaces the whole
+// value of each assignment whose KEY matches one of the patterns, and leaves
+// everything else alone. So a secret value that also happens to appear
+// elsewhere (for example, as part of another variable's value) is not
+// redacted there.
+//
+// A value beginning with a quote that isn't closed on the same line is
+// redacted up to the closing quote, however many lines that takes (or to the
+// end of the output, if it is never closed). The lines of the value are
+// replaced by a single substitution.
+//
+// A line longer than crMaxLine is not held in full: what there is of it is
+// redacted, and the rest of it is passed through as it arrives (or dropped,
+// if it is part of a redacted value).
+type EnvDumpRedactor struct {
+	dst      io.Writer
+	subst    []byte
+	patterns []string
+
+	// Incomplete line, waiting for the rest.
+	line []byte
+
+	// Whether the rest of an overlong line is being passed through, and
+	// whether it is being dropped instead.
+	long, dropLong bool
+
+	// If not zero, the quote that will end the multi-line value currently
+	// being redacted.
+	quote byte
+}
+
+// NewEnvDumpRedactor returns a new EnvDumpRedactor that writes to dst,
+// replacing the values of variables whose names match any of the patterns
+// (as with VarsToRedact) with subst.
+func NewEnvDumpRedactor(dst io.Writer, subst string, patterns []string) *EnvDumpRedactor {
+	return &EnvDumpRedactor{
+		dst:      dst,
+		subst:    []byte(subst),
+		patterns: patterns,
+	}
+}
+
+// Write redacts each complete line of b, and holds on to any incomplete line
+// at the end until it is completed by a later Write (or Flush is called).
+func (e *EnvDumpRedactor) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if e.long {
+			// The rest of an overlong line, up to its line ending.
+			end := i
+			if end < 0 {
+				end = len(b)
+			}
+			if !e.dropLong {
+				if _, err := e.dst.Write(b[:end]); err != nil {
+					return n - len(b), err
+				}
+			}
+			b = b[end:]
+			if i >= 0 {
+				e.long = false
+			}
+			continue
+		}
+		if i < 0 {
+			e.line = append(e.line, b...)
+			if len(e.line) >= crMaxLine {
+				// Don't hold on to an overlong line: redact what there is
+				// of it now, and the rest of it as it arrives.
+				if err := e.redactLine(); err != nil {
+					return n, err
+				}
+				e.long = e.quote == 0
+			}
+			break
+		}
+		e.line = append(e.line, b[:i+1]...)
+		b = b[i+1:]
+		if err := e.redactLine(); err != nil {
+			return n - len(b), err
+		}
+	}
+	return n, nil
+}
+
+// Flush redacts and writes any incomplete line.
+func (e *EnvDumpRedactor) Flush() error {
+	if len(e.line) == 0 {
+		return nil
+	}
+	return e.redactLine()
+}
+
+// redactLine redacts and writes e.line.
+func (e *EnvDumpRedactor) redactLine() error {
+	line := e.line
+	e.line = e.line[:0]
+	e.dropLong = false
+
+	if e.quote != 0 {
+		// Continuing a multi-line value: drop it up to the closing quote.
+		end := closingQuote(line, e.quote)
+		if end < 0 {
+			return nil
+		}
+		e.quote = 0
+		_, err := e.dst.Write(line[end:])
+		return err
+	}
+
+	// Split the line into its assignment and line ending.
+	body := bytes.TrimRight(line, "\r\n")
+	eol := line[len(body):]
+
+	// Tolerate the prefixes added by shells listing exported variables.
+	start := 0
+	for _, prefix := range []string{"export ", "declare -x "} {
+		if bytes.HasPrefix(body, []byte(prefix)) {
+			start = len(prefix)
+			break
+		}
+	}
+	eq := bytes.IndexByte(body[start:], '=')
+	if eq < 0 || !isEnvName(body[start:start+eq]) || !e.matches(string(body[start:start+eq])) {
+		_, err := e.dst.Write(line)
+		return err
+	}
+
+	valueStart := start + eq + 1
+	value := body[valueStart:]
+	if len(value) == 0 {
+		_, err := e.dst.Write(line)
+		return err
+	}
+
+	var out []byte
+	out = append(out, body[:valueStart]...)
+	if q := value[0]; q == '"' || q == '\'' {
+		out = append(out, q)
+		out = append(out, e.subst...)
+		end := closingQuote(value[1:], q)
+		if end < 0 {
+			// The value continues onto the next line.
+			e.quote = q
+			_, err := e.dst.Write(out)
+			return err
+		}
+		out = append(out, value[1+end:]...)
+	} else {
+		out = append(out, e.subst...)
+		// If the line is incomplete, the rest of it is also the value.
+		e.dropLong = len(eol) == 0
+	}
+	out = append(out, eol...)
+	_, err := e.dst.Write(out)
+	return err
+}
+
+// matches reports whether name matches any of the patterns.
+func (e *EnvDumpRedactor) matches(name string) bool {
+	return matchesAny(e.patterns, name)
+}
+
+// closingQuote returns the index of the first q in b that ends a quoted value,
+// or -1 if there isn't one. Within double quotes, a quote escaped by a
+// backslash doesn't end the value.
+func closingQuote(b []byte, q byte) int {
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			if q == '"' {
+				i++
+			}
+		case q:
+			return i
+		}
+	}
+	return -1
+}
+
+// isEnvName reports whether b is a valid environment variable name.
+func isEnvName(b []byte) bool {
+	if len(b) == 0 || (b[0] >= '0' && b[0] <= '9') {
+		return false
+	}
+	for _, c := range b {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package redactor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvDumpRedactor(t *testing.T) {
+	t.Parallel()
+
+	input := "HOME=/root\n" +
+		"MY_TOKEN=hunter2hunter2\n" +
+		"NOTE=the hunter2hunter2 appears here too\n" +
+		"EMPTY_TOKEN=\n" +
+		"export DB_PASSWORD=\"multi\n" +
+		"line \\\" MY_TOKEN=not a key\n" +
+		"secret\" # trailing\n" +
+		"declare -x API_TOKEN='quoted'\r\n" +
+		"not an assignment MY_TOKEN=hunter2hunter2\n" +
+		"SHELL=/bin/bash"
+
+	want := "HOME=/root\n" +
+		"MY_TOKEN=[REDACTED]\n" +
+		"NOTE=the hunter2hunter2 appears here too\n" +
+		"EMPTY_TOKEN=\n" +
+		"export DB_PASSWORD=\"[REDACTED]\" # trailing\n" +
+		"declare -x API_TOKEN='[REDACTED]'\r\n" +
+		"not an assignment MY_TOKEN=hunter2hunter2\n" +
+		"SHELL=/bin/bash"
+
+	for _, size := range []int{1, 5, len(input)} {
+		var buf strings.Builder
+		e := NewEnvDumpRedactor(&buf, "[REDACTED]", []string{"*_TOKEN", "*_PASSWORD"})
+		writeInPieces(e, []byte(input), size)
+		if err := e.Flush(); err != nil {
+			t.Fatalf("e.Flush() error = %v", err)
+		}
+
+		if got := buf.String(); got != want {
+			t.Errorf("pieces of %d: post-redaction buf.String() = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestEnvDumpRedactorUnclosedQuote(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	e := NewEnvDumpRedactor(&buf, "[REDACTED]", []string{"*_KEY"})
+	e.Write([]byte("A=1\nSSH_KEY='-----BEGIN\nabc\ndef\n"))
+	e.Flush()
+
+	if got, want := buf.String(), "A=1\nSSH_KEY='[REDACTED]"; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestEnvDumpRedactorLongLine(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("x", 3*crMaxLine)
+	input := "MY_TOKEN=" + long + "\n" +
+		"NOTE=" + long + "\n" +
+		"API_TOKEN='" + long + "' " + long + "\n" +
+		"SHELL=/bin/bash\n"
+
+	want := "MY_TOKEN=[REDACTED]\n" +
+		"NOTE=" + long + "\n" +
+		"API_TOKEN='[REDACTED]' " + long + "\n" +
+		"SHELL=/bin/bash\n"
+
+	for _, size := range []int{4096, len(input)} {
+		var buf strings.Builder
+		e := NewEnvDumpRedactor(&buf, "[REDACTED]", []string{"*_TOKEN"})
+		for b := []byte(input); len(b) > 0; {
+			n := size
+			if n > len(b) {
+				n = len(b)
+			}
+			e.Write(b[:n])
+			b = b[n:]
+			if len(e.line) > crMaxLine+size {
+				t.Fatalf("pieces of %d: holding %d bytes of an incomplete line", size, len(e.line))
+			}
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatalf("e.Flush() error = %v", err)
+		}
+
+		if got := buf.String(); got != want {
+			t.Errorf("pieces of %d: post-redaction buf.String() differs (%d bytes, want %d)", size, len(got), len(want))
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package redactor
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ExpandOptions selects the forms of each secret returned by ExpandNeedles.
+// Each corresponds to the option of the same name.
+type ExpandOptions struct {
+	UnicodeNormalization bool // WithUnicodeNormalization
+	HTMLEscapedNeedles   bool // WithHTMLEscapedNeedles
+	JSONByteArrayNeedles bool // WithJSONByteArrayNeedles
+	ReversedNeedles      bool // WithReversedNeedles
+}
+
+// ExpandNeedles returns values, along with the forms of them selected by opts,
+// without duplicates or empty values. Passing the result to New or Reset (or
+// NewNeedleSet) redacts the same needles as passing values to a redactor with
+// the corresponding options, but the expansion is done once, up front, and
+// can be shared between redactors. As with the options, only values at least
+// RedactLengthMin bytes long are expanded.
+//
+// There is no counterpart to WithQuotedPrintableNeedles, since its needles
+// need Needle.Delimiters, which can't be expressed as strings.
+func ExpandNeedles(values []string, opts ExpandOptions) []string {
+	o := options{
+		normalizeUnicode: opts.UnicodeNormalization,
+		htmlEscape:       opts.HTMLEscapedNeedles,
+		jsonByteArray:    opts.JSONByteArrayNeedles,
+		reversed:         opts.ReversedNeedles,
+	}
+	var needles []string
+	o.expandNeedles(NeedleSlice(values), func(n Needle) {
+		needles = append(needles, n.Value)
+	})
+	return needles
+}
+
+// expansion is the options that affect expandNeedles. Redactors with equal
+// expansions derive the same needles from the same values.
+type expansion struct {
+	normalizeUnicode, htmlEscape, jsonByteArray, quotedPrintable, reversed bool
+}
+
+// expansion returns the options that affect expandNeedles.
+func (o *options) expansion() expansion {
+	return expansion{
+		normalizeUnicode: o.normalizeUnicode,
+		htmlEscape:       o.htmlEscape,
+		jsonByteArray:    o.jsonByteArray,
+		quotedPrintable:  o.quotedPrintable,
+		reversed:         o.reversed,
+	}
+}
+
+// needleSet builds a NeedleSet from the values provided by src, along with any
+// needles derived from them by the enabled options.
+func (o *options) needleSet(src NeedleSource) *NeedleSet {
+	set := &NeedleSet{}
+	o.expandNeedles(src, set.add)
+	set.finish()
+	return set
+}
+
+// rebuildNeedleSet is like needleSet, but reuses the parts of old that are
+// unchanged, if only a few needles have changed. See NeedleSet.rebuild.
+func (o *options) rebuildNeedleSet(old *NeedleSet, values []string) *NeedleSet {
+	if len(values) == 0 {
+		// Resetting to no needles is common (e.g. between jobs), and needs no
+		// new set.
+		return emptyNeedleSet
+	}
+	needles := make([]Needle, 0, len(values))
+	o.expandNeedles(NeedleSlice(values), func(n Needle) {
+		needles = append(needles, n)
+	})
+	return old.rebuild(needles)
+}
+
+// expandNeedles calls f with a needle for each of the values provided by src,
+// along with any needles derived from them by the enabled options. Duplicate
+// and empty needles are skipped.
+//
+// Derived needles are only generated for values at least RedactLengthMin
+// bytes long, since a short value is more likely to be a false positive, and
+// deriving more forms of it only makes that worse.
+func (o *options) expandNeedles(src NeedleSource, f func(Needle)) {
+	if src == nil {
+		return
+	}
+	type key struct{ value, delimiters string }
+	seen := make(map[key]bool)
+	addNeedle := func(n Needle) {
+		k := key{n.Value, n.Delimiters}
+		if n.Value == "" || seen[k] {
+			return
+		}
+		seen[k] = true
+		f(n)
+	}
+	add := func(s string) {
+		addNeedle(Needle{Value: s})
+	}
+
+	src.Each(func(v string) {
+		add(v)
+		if len(v) < RedactLengthMin {
+			return
+		}
+		if o.normalizeUnicode && !isASCII(v) {
+			add(norm.NFC.String(v))
+			add(norm.NFD.String(v))
+		}
+		if o.htmlEscape {
+			add(html.EscapeString(v))
+		}
+		if o.jsonByteArray {
+			add(jsonByteArray(v, ","))
+			add(jsonByteArray(v, ", "))
+		}
+		if o.quotedPrintable {
+			// Even when encoding changes nothing, the encoded form may be
+			// split by soft line breaks.
+			addNeedle(Needle{Value: quotedPrintable(v), Delimiters: softLineBreak})
+		}
+		if o.reversed {
+			add(reverseBytes(v))
+			if !isASCII(v) {
+				add(reverseRunes(v))
+			}
+		}
+	})
+}
+
+// jsonByteArray renders s as a JSON array of its byte values, separated by
+// sep.
+func jsonByteArray(s, sep string) string {
+	var b strings.Builder
+	b.Grow(len(s)*(3+len(sep)) + 2)
+	b.WriteByte('[')
+	for i := 0; i < len(s); i++ {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(strconv.Itoa(int(s[i])))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// softLineBreak holds the bytes of the soft line breaks of quoted-printable
+// encoding, "=\n" or "=\r\n".
+const softLineBreak = "=\r\n"
+
+// quotedPrintable encodes s in quoted-printable encoding, without soft line
+// breaks. Unlike mime/quotedprintable, spaces and tabs are never encoded: an
+// encoder only encodes them at the end of a line, where they are unlikely to
+// be part of a secret.
+func quotedPrintable(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || ('!' <= c && c <= '~' && c != '='):
+			b.WriteByte(c)
+		default:
+			b.WriteByte('=')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0x0f])
+		}
+	}
+	return b.String()
+}
+
+// reverseBytes returns s with its bytes in reverse order.
+func reverseBytes(s string) string {
+	b := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		b[len(s)-1-i] = s[i]
+	}
+	return string(b)
+}
+
+// reverseRunes returns s with its UTF-8 encoded characters in reverse order.
+// Invalid UTF-8 is reversed byte by byte.
+func reverseRunes(s string) string {
+	b := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		_, size := utf8.DecodeLastRuneInString(s)
+		b = append(b, s[len(s)-size:]...)
+		s = s[:len(s)-size]
+	}
+	return string(b)
+}
+
+// isASCII reports whether s contains only ASCII.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,398 @@
+package redactor
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"mime/quotedprintable"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestRedactorUnicodeNormalization(t *testing.T) {
+	t.Parallel()
+
+	secret := "pässwörd-crème-brûlée"
+	nfc, nfd := norm.NFC.String(secret), norm.NFD.String(secret)
+	if nfc == nfd {
+		t.Fatalf("NFC and NFD forms of %q are equal", secret)
+	}
+
+	for _, test := range []struct {
+		desc          string
+		needle, input string
+	}{
+		{desc: "NFC needle, NFD input", needle: nfc, input: nfd},
+		{desc: "NFD needle, NFC input", needle: nfd, input: nfc},
+		{desc: "NFC needle, NFC input", needle: nfc, input: nfc},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := New(&buf, "[REDACTED]", []string{test.needle}, WithUnicodeNormalization())
+			fmt.Fprintf(redactor, "password=%s\n", test.input)
+			redactor.Flush()
+
+			if got, want := buf.String(), "password=[REDACTED]\n"; got != want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRedactorUnicodeNormalizationDisabled(t *testing.T) {
+	t.Parallel()
+
+	secret := "pässwörd-crème-brûlée"
+	input := fmt.Sprintf("password=%s\n", norm.NFD.String(secret))
+
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{norm.NFC.String(secret)})
+	fmt.Fprint(redactor, input)
+	redactor.Flush()
+
+	if got, want := buf.String(), input; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestExpandNeedlesUnicodeNormalization(t *testing.T) {
+	t.Parallel()
+
+	o := options{normalizeUnicode: true}
+	expand := func(values ...string) []Needle {
+		var needles []Needle
+		o.expandNeedles(NeedleSlice(values), func(n Needle) {
+			needles = append(needles, n)
+		})
+		return needles
+	}
+
+	// ASCII values, and short values, are not expanded.
+	short := norm.NFC.String("äé")
+	got := expand("hunter2hunter2", short)
+	if len(got) != 2 {
+		t.Errorf("o.expandNeedles(ASCII and short values) = %v, want 2 needles", got)
+	}
+
+	got = expand(norm.NFC.String("crème-brûlée"))
+	if len(got) != 2 {
+		t.Errorf("o.expandNeedles(non-ASCII value) = %v, want 2 needles", got)
+	}
+}
+
+func TestRedactorHTMLEscapedNeedles(t *testing.T) {
+	t.Parallel()
+
+	secret := `tom&jerry<3'"`
+	escaped := html.EscapeString(secret)
+	if want := "tom&amp;jerry&lt;3&#39;&#34;"; escaped != want {
+		t.Fatalf("html.EscapeString(%q) = %q, want %q", secret, escaped, want)
+	}
+
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{secret, "a&b"}, WithHTMLEscapedNeedles())
+	fmt.Fprintf(redactor, "<p>raw: %s</p>\n", secret)
+	fmt.Fprintf(redactor, "<p>escaped: %s</p>\n", escaped)
+	fmt.Fprintf(redactor, "<p>short: a&b, %s</p>\n", html.EscapeString("a&b"))
+	redactor.Flush()
+
+	// "a&b" is shorter than RedactLengthMin, so its escaped form is not
+	// redacted.
+	want := "<p>raw: [REDACTED]</p>\n" +
+		"<p>escaped: [REDACTED]</p>\n" +
+		"<p>short: [REDACTED], a&amp;b</p>\n"
+	if got := buf.String(); got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactorHTMLEscapedNeedlesDisabled(t *testing.T) {
+	t.Parallel()
+
+	secret := "tom&jerry<3'"
+	input := fmt.Sprintf("<p>%s</p>\n", html.EscapeString(secret))
+
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{secret})
+	fmt.Fprint(redactor, input)
+	redactor.Flush()
+
+	if got, want := buf.String(), input; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactorJSONByteArrayNeedles(t *testing.T) {
+	t.Parallel()
+
+	// encoding/json renders []byte as base64, so render the secret the way
+	// serializers that treat it as a list of numbers do.
+	secret := "hunter2hunter2"
+	ints := make([]int, len(secret))
+	for i := range secret {
+		ints[i] = int(secret[i])
+	}
+	compact, err := json.Marshal(struct{ Password []int }{ints})
+	if err != nil {
+		t.Fatalf("json.Marshal(secret as []int) error = %v", err)
+	}
+
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{secret, "hi"}, WithJSONByteArrayNeedles())
+	fmt.Fprintf(redactor, "%s\n", compact)
+	fmt.Fprintf(redactor, "%s\n", strings.ReplaceAll(string(compact), ",", ", "))
+	fmt.Fprint(redactor, "short: [104,105]\n")
+	redactor.Flush()
+
+	// "hi" is shorter than RedactLengthMin, so its byte array isn't redacted.
+	want := `{"Password":[REDACTED]}` + "\n" +
+		`{"Password":[REDACTED]}` + "\n" +
+		"short: [104,105]\n"
+	if got := buf.String(); got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactorQuotedPrintableNeedles(t *testing.T) {
+	t.Parallel()
+
+	secret := "s3cr=t/päss=word"
+
+	// Encode the secret far enough along a line that the encoder has to split
+	// it with a soft line break.
+	var encoded strings.Builder
+	qp := quotedprintable.NewWriter(&encoded)
+	fmt.Fprintf(qp, "%s %s\n", strings.Repeat("x", 64), secret)
+	qp.Close()
+	if !strings.Contains(encoded.String(), "=\r\n") {
+		t.Fatalf("quoted-printable encoding %q has no soft line break", encoded.String())
+	}
+
+	for _, test := range []struct {
+		desc, input, want string
+	}{
+		{
+			desc:  "soft line break",
+			input: encoded.String(),
+			want:  strings.Repeat("x", 64) + " [REDACTED]\r\n",
+		},
+		{
+			desc:  "no line break",
+			input: "password: s3cr=3Dt/p=C3=A4ss=3Dword\n",
+			want:  "password: [REDACTED]\n",
+		},
+		{
+			desc:  "bare LF soft line break",
+			input: "password: s3cr=3D=\nt/p=C3=\n=A4ss=3Dword\n",
+			want:  "password: [REDACTED]\n",
+		},
+		{
+			// "hi=x" is shorter than RedactLengthMin, so isn't encoded.
+			desc:  "short secret",
+			input: "short: hi=3Dx\n",
+			want:  "short: hi=3Dx\n",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := New(&buf, "[REDACTED]", []string{secret, "hi=x"}, WithQuotedPrintableNeedles())
+			writeInPieces(redactor, []byte(test.input), 3)
+			redactor.Flush()
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactorReversedNeedles(t *testing.T) {
+	t.Parallel()
+
+	secrets := []string{"hunter2hunter2", "pässwörd", "abc"}
+
+	for _, test := range []struct {
+		desc, input, want string
+	}{
+		{
+			desc:  "reversed",
+			input: "token: 2retnuh2retnuh\n",
+			want:  "token: [REDACTED]\n",
+		},
+		{
+			desc:  "forwards too",
+			input: "token: hunter2hunter2\n",
+			want:  "token: [REDACTED]\n",
+		},
+		{
+			desc:  "reversed by character",
+			input: "password: dröwssäp\n",
+			want:  "password: [REDACTED]\n",
+		},
+		{
+			desc:  "reversed by byte",
+			input: "password: " + reverseBytes("pässwörd") + "\n",
+			want:  "password: [REDACTED]\n",
+		},
+		{
+			// "abc" is shorter than RedactLengthMin, so isn't reversed.
+			desc:  "short secret",
+			input: "short: abc cba\n",
+			want:  "short: [REDACTED] cba\n",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := New(&buf, "[REDACTED]", nil, WithReversedNeedles())
+			redactor.Reset(secrets)
+			writeInPieces(redactor, []byte(test.input), 3)
+			redactor.Flush()
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactorReversedNeedlesOffByDefault(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{"hunter2hunter2"})
+	fmt.Fprint(redactor, "token: 2retnuh2retnuh\n")
+	redactor.Flush()
+
+	if got, want := buf.String(), "token: 2retnuh2retnuh\n"; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestExpandNeedles(t *testing.T) {
+	t.Parallel()
+
+	values := []string{"pässwörd-crème", "<secret&1111>", "hunter2", "hunter2", "abc", ""}
+
+	tests := []struct {
+		desc       string
+		expandOpts ExpandOptions
+		opts       []Option
+	}{
+		{desc: "none"},
+		{
+			desc:       "Unicode normalization",
+			expandOpts: ExpandOptions{UnicodeNormalization: true},
+			opts:       []Option{WithUnicodeNormalization()},
+		},
+		{
+			desc:       "HTML escaped",
+			expandOpts: ExpandOptions{HTMLEscapedNeedles: true},
+			opts:       []Option{WithHTMLEscapedNeedles()},
+		},
+		{
+			desc:       "JSON byte array",
+			expandOpts: ExpandOptions{JSONByteArrayNeedles: true},
+			opts:       []Option{WithJSONByteArrayNeedles()},
+		},
+		{
+			desc:       "reversed",
+			expandOpts: ExpandOptions{ReversedNeedles: true},
+			opts:       []Option{WithReversedNeedles()},
+		},
+		{
+			desc: "all",
+			expandOpts: ExpandOptions{
+				UnicodeNormalization: true,
+				HTMLEscapedNeedles:   true,
+				JSONByteArrayNeedles: true,
+				ReversedNeedles:      true,
+			},
+			opts: []Option{
+				WithUnicodeNormalization(),
+				WithHTMLEscapedNeedles(),
+				WithJSONByteArrayNeedles(),
+				WithReversedNeedles(),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got := ExpandNeedles(values, test.expandOpts)
+			seen := make(map[string]bool)
+			for _, n := range got {
+				if n == "" || seen[n] {
+					t.Errorf("ExpandNeedles(values, %+v) contains %q more than once, or empty", test.expandOpts, n)
+				}
+				seen[n] = true
+			}
+
+			// The expansion is the same as the redactor's own.
+			var want []string
+			New(io.Discard, "[REDACTED]", values, test.opts...).needles.each(func(n *needle) {
+				want = append(want, n.value)
+			})
+			sort.Strings(got)
+			sort.Strings(want)
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("ExpandNeedles(values, %+v) diff (-got +want):\n%s", test.expandOpts, diff)
+			}
+		})
+	}
+}
+
+func TestMuxResetExpandsNeedles(t *testing.T) {
+	t.Parallel()
+
+	secret := "a&b<c'def"
+	input := fmt.Sprintf("raw %s, escaped %s, reversed %s\n", secret, html.EscapeString(secret), reverseBytes(secret))
+
+	var bufs [4]strings.Builder
+	mux := Mux{
+		New(&bufs[0], "[REDACTED]", nil),
+		New(&bufs[1], "[REDACTED]", nil, WithHTMLEscapedNeedles()),
+		New(&bufs[2], "[REDACTED]", nil, WithReversedNeedles()),
+		New(&bufs[3], "[REDACTED]", nil, WithHTMLEscapedNeedles()),
+	}
+	mux.Reset([]string{secret})
+	for _, r := range mux {
+		fmt.Fprint(r, input)
+	}
+	if err := mux.Flush(); err != nil {
+		t.Fatalf("mux.Flush() = %v", err)
+	}
+
+	want := []string{
+		fmt.Sprintf("raw [REDACTED], escaped %s, reversed %s\n", html.EscapeString(secret), reverseBytes(secret)),
+		fmt.Sprintf("raw [REDACTED], escaped [REDACTED], reversed %s\n", reverseBytes(secret)),
+		fmt.Sprintf("raw [REDACTED], escaped %s, reversed [REDACTED]\n", html.EscapeString(secret)),
+		fmt.Sprintf("raw [REDACTED], escaped [REDACTED], reversed %s\n", reverseBytes(secret)),
+	}
+	for i := range bufs {
+		if got := bufs[i].String(); got != want[i] {
+			t.Errorf("redactor %d output = %q, want %q", i, got, want[i])
+		}
+	}
+
+	// Redactors with the same options share a NeedleSet.
+	if mux[1].needles != mux[3].needles {
+		t.Errorf("redactors with the same options have different NeedleSets, want them shared")
+	}
+}
@@ -0,0 +1,222 @@
+package redactor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrFanOutClosed is returned when writing to a FanOut that has been closed.
+var ErrFanOutClosed = errors.New("write to closed fan-out")
+
+// ErrFanOutBufferFull is the error of a FanOut destination that fell so far
+// behind that its buffer filled up (see NewFanOut).
+var ErrFanOutBufferFull = errors.New("buffer full")
+
+// FanOutErrorPolicy is what a FanOut does when one of its destinations returns
+// an error.
+type FanOutErrorPolicy int
+
+const (
+	// FanOutDropFailed stops writing to a destination once it returns an
+	// error, and keeps writing to the others. The errors are returned by
+	// Close.
+	FanOutDropFailed FanOutErrorPolicy = iota
+
+	// FanOutFailFast causes Write (and Close) to return an error once any
+	// destination has returned one.
+	FanOutFailFast
+)
+
+// FanOut writes its input to several destinations, each from its own
+// goroutine with its own buffer, so that a slow destination only holds up
+// its own buffer, not the others. Use it as the destination of a Redactor to
+// redact once and deliver the same redacted output to each destination.
+//
+// A FanOut made by NewFanOut never blocks on a destination: a destination
+// whose buffer is too full for a Write fails (with ErrFanOutBufferFull), and
+// is handled according to the FanOutErrorPolicy, like one that returned an
+// error. One made by NewFanOutWithBackpressure instead waits for room in the
+// buffer, so that a slow destination holds up Write, but doesn't fail.
+type FanOut struct {
+	mu           sync.Mutex
+	sinks        []*fanOutSink
+	policy       FanOutErrorPolicy
+	bufBytes     int
+	backpressure bool
+	closed       bool
+}
+
+// fanOutSink is a destination of a FanOut.
+type fanOutSink struct {
+	i    int
+	dst  io.Writer
+	done chan struct{}
+
+	mu     sync.Mutex
+	cond   sync.Cond // signalled when the queue or err changes, or on close
+	queue  [][]byte
+	queued int // bytes in queue, and being written
+	closed bool
+	err    error
+}
+
+// NewFanOut returns a FanOut that writes to each of dsts, buffering up to
+// bufBytes bytes for each. A Write larger than bufBytes is still buffered
+// for a destination with nothing else buffered.
+func NewFanOut(bufBytes int, policy FanOutErrorPolicy, dsts ...io.Writer) *FanOut {
+	f := &FanOut{policy: policy, bufBytes: bufBytes}
+	for i, dst := range dsts {
+		s := &fanOutSink{
+			i:    i,
+			dst:  dst,
+			done: make(chan struct{}),
+		}
+		s.cond.L = &s.mu
+		f.sinks = append(f.sinks, s)
+		go s.run()
+	}
+	return f
+}
+
+// NewFanOutWithBackpressure is like NewFanOut, but Write waits for room in
+// the buffer of a destination that has fallen behind, rather than failing it.
+func NewFanOutWithBackpressure(bufBytes int, policy FanOutErrorPolicy, dsts ...io.Writer) *FanOut {
+	f := NewFanOut(bufBytes, policy, dsts...)
+	f.backpressure = true
+	return f
+}
+
+// run writes the buffered writes to the destination, until the sink is
+// closed and the buffer is empty. After an error it discards the rest.
+func (s *fanOutSink) run() {
+	defer close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			return
+		}
+		b := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		failed := s.err != nil
+
+		s.mu.Unlock()
+		var err error
+		if !failed {
+			_, err = s.dst.Write(b)
+		}
+		s.mu.Lock()
+
+		s.queued -= len(b)
+		if err != nil && s.err == nil {
+			s.err = fmt.Errorf("fan-out destination %d: %w", s.i, err)
+		}
+		s.cond.Broadcast()
+	}
+}
+
+// enqueue buffers b to be written to the destination, unless it has failed.
+// If the buffer is too full for b, it waits for room if wait is set, and
+// otherwise fails the destination and returns the error.
+func (s *fanOutSink) enqueue(b []byte, bufBytes int, wait bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.err == nil && s.queued > 0 && s.queued+len(b) > bufBytes {
+		if !wait {
+			s.err = fmt.Errorf("fan-out destination %d: %w", s.i, ErrFanOutBufferFull)
+			return s.err
+		}
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return nil
+	}
+	s.queue = append(s.queue, b)
+	s.queued += len(b)
+	s.cond.Broadcast()
+	return nil
+}
+
+// close stops the sink once everything buffered has been written.
+func (s *fanOutSink) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	<-s.done
+}
+
+// failed returns the error from the destination, if it has failed.
+func (s *fanOutSink) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Write queues a copy of b to be written to each destination. Destinations
+// that have failed are skipped. With FanOutFailFast, once any destination has
+// failed, Write returns its error instead.
+//
+// If this Write fails a destination because its buffer is full, Write returns
+// the error, whatever the FanOutErrorPolicy, so that the failure is noticed
+// when it happens. It still returns len(b), since b was queued for the other
+// destinations, and shouldn't be written again.
+func (f *FanOut) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, ErrFanOutClosed
+	}
+	if f.policy == FanOutFailFast {
+		if err := f.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	// The copy is shared by all the destinations, which only read it.
+	c := append([]byte(nil), b...)
+	var errs []error
+	for _, s := range f.sinks {
+		if err := s.enqueue(c, f.bufBytes, f.backpressure); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(b), errors.Join(errs...)
+}
+
+// Close waits for each destination to be written everything buffered for it,
+// and returns the errors from any that failed. Close does not close the
+// destinations.
+func (f *FanOut) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for _, s := range f.sinks {
+		s.close()
+	}
+	return f.Err()
+}
+
+// Err returns the errors from the destinations that have failed so far, if
+// any, joined.
+func (f *FanOut) Err() error {
+	var errs []error
+	for _, s := range f.sinks {
+		if err := s.failed(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,241 @@
+package redactor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// syncBuilder is a strings.Builder that is safe to read while it is written.
+type syncBuilder struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (b *syncBuilder) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Write(p)
+}
+
+func (b *syncBuilder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.String()
+}
+
+// blockingWriter blocks each Write until release is closed.
+type blockingWriter struct {
+	syncBuilder
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.syncBuilder.Write(p)
+}
+
+func TestFanOutSlowDestination(t *testing.T) {
+	t.Parallel()
+
+	slow := &blockingWriter{release: make(chan struct{})}
+	var fast syncBuilder
+	fanOut := NewFanOut(4096, FanOutDropFailed, slow, &fast)
+	redactor := New(fanOut, "[REDACTED]", []string{"secret1111"})
+
+	var want strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(redactor, "line %d secret1111\n", i)
+		fmt.Fprintf(&want, "line %d [REDACTED]\n", i)
+	}
+	redactor.Flush()
+
+	// The fast destination gets everything, while the slow one is blocked.
+	deadline := time.Now().Add(10 * time.Second)
+	for fast.String() != want.String() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := fast.String(); got != want.String() {
+		t.Errorf("while slow destination blocked, fast.String() = %q, want %q", got, want.String())
+	}
+	if got := slow.String(); got != "" {
+		t.Errorf("while slow destination blocked, slow.String() = %q, want empty", got)
+	}
+
+	close(slow.release)
+	if err := fanOut.Close(); err != nil {
+		t.Errorf("fanOut.Close() = %v", err)
+	}
+	if got := slow.String(); got != want.String() {
+		t.Errorf("after release, slow.String() = %q, want %q", got, want.String())
+	}
+}
+
+func TestFanOutStuckDestination(t *testing.T) {
+	t.Parallel()
+
+	stuck := &blockingWriter{release: make(chan struct{})}
+	var healthy syncBuilder
+	fanOut := NewFanOut(64, FanOutDropFailed, stuck, &healthy)
+
+	// The stuck destination never reads, so its buffer fills up, but the
+	// writes are not held up. The Write that finds the buffer full returns
+	// the error.
+	var want strings.Builder
+	var fullErrs int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			line := fmt.Sprintf("line %d\n", i)
+			n, err := fanOut.Write([]byte(line))
+			if n != len(line) {
+				t.Errorf("fanOut.Write(%q) = %d, want %d", line, n, len(line))
+			}
+			switch {
+			case errors.Is(err, ErrFanOutBufferFull):
+				fullErrs++
+			case err != nil:
+				t.Errorf("fanOut.Write(%q) = %v", line, err)
+			}
+			want.WriteString(line)
+			// Let the healthy destination keep up.
+			for healthy.String() != want.String() {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("fanOut.Write blocked on a stuck destination")
+	}
+	if fullErrs != 1 {
+		t.Errorf("fanOut.Write returned %v %d times, want once", ErrFanOutBufferFull, fullErrs)
+	}
+
+	if err := fanOut.Err(); !errors.Is(err, ErrFanOutBufferFull) {
+		t.Errorf("fanOut.Err() = %v, want %v", err, ErrFanOutBufferFull)
+	}
+
+	close(stuck.release)
+	if err := fanOut.Close(); !errors.Is(err, ErrFanOutBufferFull) {
+		t.Errorf("fanOut.Close() = %v, want %v", err, ErrFanOutBufferFull)
+	}
+	if got := healthy.String(); got != want.String() {
+		t.Errorf("healthy.String() = %q, want %q", got, want.String())
+	}
+}
+
+func TestFanOutBackpressure(t *testing.T) {
+	t.Parallel()
+
+	slow := &blockingWriter{release: make(chan struct{})}
+	var fast syncBuilder
+	fanOut := NewFanOutWithBackpressure(64, FanOutDropFailed, slow, &fast)
+
+	// Much more than fits in the buffer.
+	var want strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&want, "line %d\n", i)
+	}
+
+	var written int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			line := fmt.Sprintf("line %d\n", i)
+			if _, err := fanOut.Write([]byte(line)); err != nil {
+				t.Errorf("fanOut.Write(%q) = %v", line, err)
+			}
+			atomic.AddInt32(&written, 1)
+		}
+	}()
+
+	// While the slow destination is blocked, Write waits for it.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatalf("all writes finished while the slow destination was blocked")
+	default:
+	}
+	if got := atomic.LoadInt32(&written); got >= 20 {
+		t.Errorf("while slow destination blocked, %d writes finished", got)
+	}
+
+	// Once it recovers, everything gets through, without errors.
+	close(slow.release)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("fanOut.Write still blocked after the slow destination recovered")
+	}
+	if err := fanOut.Close(); err != nil {
+		t.Errorf("fanOut.Close() = %v", err)
+	}
+	if got := slow.String(); got != want.String() {
+		t.Errorf("slow.String() = %q, want %q", got, want.String())
+	}
+	if got := fast.String(); got != want.String() {
+		t.Errorf("fast.String() = %q, want %q", got, want.String())
+	}
+}
+
+func TestFanOutFailedDestination(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		desc      string
+		policy    FanOutErrorPolicy
+		wantWrite bool // whether the healthy destination gets writes after the failure
+	}{
+		{desc: "drop failed", policy: FanOutDropFailed, wantWrite: true},
+		{desc: "fail fast", policy: FanOutFailFast},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			failing := &flakyWriter{budget: 3}
+			var healthy syncBuilder
+			fanOut := NewFanOut(4096, test.policy, failing, &healthy)
+
+			fanOut.Write([]byte("first\n"))
+
+			// Wait for the failure to be noticed.
+			deadline := time.Now().Add(10 * time.Second)
+			for fanOut.Err() == nil && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if err := fanOut.Err(); !errors.Is(err, errFlaky) {
+				t.Fatalf("fanOut.Err() = %v, want %v", err, errFlaky)
+			}
+
+			_, err := fanOut.Write([]byte("second\n"))
+			want := "first\n"
+			if test.wantWrite {
+				if err != nil {
+					t.Errorf("after failure, fanOut.Write() = %v, want nil", err)
+				}
+				want += "second\n"
+			} else if !errors.Is(err, errFlaky) {
+				t.Errorf("after failure, fanOut.Write() = %v, want %v", err, errFlaky)
+			}
+
+			if err := fanOut.Close(); !errors.Is(err, errFlaky) {
+				t.Errorf("fanOut.Close() = %v, want %v", err, errFlaky)
+			}
+			if got := healthy.String(); got != want {
+				t.Errorf("healthy.String() = %q, want %q", got, want)
+			}
+			if _, err := fanOut.Write([]byte("third\n")); !errors.Is(err, ErrFanOutClosed) {
+				t.Errorf("after Close, fanOut.Write() = %v, want %v", err, ErrFanOutClosed)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package redactor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+)
+
+// NeedleFileSizeMax is the largest file NeedlesFromFiles reads a secret from.
+// Secrets are rarely more than a few kilobytes, and each needle has to be
+// held (and matched) in full, so larger files are skipped.
+const NeedleFileSizeMax = 64 * 1024
+
+// NeedlesFromFiles returns the secrets to redact held in the files matching
+// glob (as with filepath.Glob), for passing to New or Reset. Each file holds
+// one secret, which is its contents with surrounding whitespace trimmed.
+//
+// Directories are ignored. Files are skipped with a warning if their secret
+// is shorter than RedactLengthMin, if they are larger than NeedleFileSizeMax,
+// or if they contain NUL bytes (which suggests a binary file, whose contents
+// are unlikely to be printed as is). It returns an error if glob is malformed,
+// or a matching file can't be read.
+func NeedlesFromFiles(logger shell.Logger, glob string) ([]string, error) {
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, fmt.Errorf("bad secret files pattern %q: %w", glob, err)
+	}
+
+	var needles []string
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		b, err := readNeedleFile(p)
+		switch {
+		case err == errIsDir:
+			continue
+		case err == errTooLarge:
+			logger.Warningf("Secret file %s is larger than %d bytes and will not be redacted", p, NeedleFileSizeMax)
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		b = bytes.TrimSpace(b)
+		if bytes.IndexByte(b, 0) >= 0 {
+			logger.Warningf("Secret file %s appears to be binary and will not be redacted", p)
+			continue
+		}
+		if len(b) < RedactLengthMin {
+			if len(b) > 0 {
+				logger.Warningf("Secret in file %s below minimum length (%d bytes) and will not be redacted", p, RedactLengthMin)
+			}
+			continue
+		}
+		if s := string(b); !seen[s] {
+			seen[s] = true
+			needles = append(needles, s)
+		}
+	}
+	return needles, nil
+}
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errTooLarge = fmt.Errorf("larger than %d bytes", NeedleFileSizeMax)
+)
+
+// readNeedleFile reads the file at p, unless it is a directory or too large.
+func readNeedleFile(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errIsDir
+	}
+
+	// Read one byte more than the limit, to tell if the file is over it
+	// without trusting the size from Stat (which may be 0 for special files).
+	b, err := io.ReadAll(io.LimitReader(f, NeedleFileSizeMax+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > NeedleFileSizeMax {
+		return nil, errTooLarge
+	}
+	return b, nil
+}
@@ -0,0 +1,63 @@
+package redactor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNeedlesFromFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"api-token":   "tok_abcdef123456\n",
+		"db-password": "  hunter2hunter2  \n",
+		"duplicate":   "tok_abcdef123456",
+		"short":       "abc\n",
+		"empty":       "",
+		"binary":      "secret\x00secret",
+		"oversized":   strings.Repeat("x", NeedleFileSizeMax+1),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o600); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0o700); err != nil {
+		t.Fatalf("os.Mkdir(subdir) error = %v", err)
+	}
+
+	var log strings.Builder
+	logger := &shell.WriterLogger{Writer: &log}
+	got, err := NeedlesFromFiles(logger, filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("NeedlesFromFiles(dir) error = %v", err)
+	}
+
+	// Files are matched in lexical order.
+	want := []string{"tok_abcdef123456", "hunter2hunter2"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("NeedlesFromFiles(dir) diff (-got +want):\n%s", diff)
+	}
+
+	for _, name := range []string{"binary", "oversized", "short"} {
+		if !strings.Contains(log.String(), filepath.Join(dir, name)) {
+			t.Errorf("NeedlesFromFiles(dir) didn't warn about %q; log:\n%s", name, log.String())
+		}
+	}
+	if strings.Contains(log.String(), "empty") {
+		t.Errorf("NeedlesFromFiles(dir) warned about an empty file; log:\n%s", log.String())
+	}
+}
+
+func TestNeedlesFromFilesBadPattern(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NeedlesFromFiles(shell.DiscardLogger, "["); err == nil {
+		t.Errorf("NeedlesFromFiles(%q) error = nil, want an error", "[")
+	}
+}
@@ -0,0 +1,301 @@
+package redactor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// gzipMagic begins every gzip member (ID1, ID2, and CM = deflate).
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// gzipMaxMember is the most input GzipTransparent will hold while waiting for
+// the end of a gzip member. Beyond this, the member is passed through as
+// though it were plain text.
+const gzipMaxMember = 16 << 20
+
+// gzipMaxDecompressed is the most a gzip member may decompress to. A member
+// that decompresses to more (such as a "gzip bomb", which is small but
+// decompresses to gigabytes) is passed through as though it were plain text,
+// rather than being decompressed in memory.
+const gzipMaxDecompressed = 64 << 20
+
+// errGzipTooLarge is returned by decodeGzip when a member decompresses to more
+// than gzipMaxDecompressed bytes.
+var errGzipTooLarge = errors.New("gzip member decompresses to too much data")
+
+// GzipTransparent wraps a Redactor so that secrets inside gzip members
+// embedded in the stream are redacted too. Plain regions of the stream are
+// written to the Redactor as usual. Each gzip member is decompressed, and
+// either redacted and recompressed in place, or (if decompress is set)
+// redacted and written out decompressed.
+//
+// While a gzip member is incomplete, it is held in a buffer until its end is
+// written, up to 16MiB, and decompressed as it arrives. Members that
+// decompress to more than 64MiB are passed through as they are. Offsets
+// reported to WithOnRedact and WithOffsetMapping do not account for gzip
+// members. Flush must be called at the end of the stream, to release the
+// resources used to decompress an incomplete member.
+type GzipTransparent struct {
+	redactor   *Redactor
+	decompress bool
+	buf        []byte
+
+	// If not nil, the decoder of the gzip member at the start of buf, which
+	// has been fed the first fed bytes of it.
+	dec *gzipDecoder
+	fed int
+}
+
+// NewGzipTransparent returns a GzipTransparent writing to r. If decompress is
+// true, gzip members are written out decompressed, otherwise they are
+// recompressed after redaction. Recompressed members are written to the
+// output as they are, which WithCarriageReturnCollapse and the DropLine policy
+// would corrupt, so it returns an error if r has either.
+func NewGzipTransparent(r *Redactor, decompress bool) (*GzipTransparent, error) {
+	if !decompress && (r.opts.collapseCR || r.opts.policy == DropLine) {
+		return nil, errors.New("can't recompress gzip members with WithCarriageReturnCollapse or the DropLine policy")
+	}
+	return &GzipTransparent{redactor: r, decompress: decompress}, nil
+}
+
+// Write redacts secrets from the stream, including within gzip members, and
+// writes the result to the Redactor.
+func (g *GzipTransparent) Write(b []byte) (int, error) {
+	g.buf = append(g.buf, b...)
+	for len(g.buf) > 0 {
+		if g.dec == nil {
+			i := bytes.Index(g.buf, gzipMagic)
+			if i < 0 {
+				// Hold back a possible start of the magic at the end.
+				keep := magicPrefixLen(g.buf)
+				return len(b), g.plain(len(g.buf) - keep)
+			}
+			if err := g.plain(i); err != nil {
+				return len(b), err
+			}
+			g.dec = newGzipDecoder()
+			g.fed = 0
+		}
+
+		res := g.dec.feed(g.buf[g.fed:])
+		switch {
+		case res.done:
+			g.dec = nil
+			n := g.fed + res.used
+			err := g.member(res.data)
+			g.buf = g.buf[n:]
+			if err != nil {
+				return len(b), err
+			}
+
+		case res.err == nil && len(g.buf) <= gzipMaxMember:
+			// The member is incomplete.
+			g.fed = len(g.buf)
+			return len(b), nil
+
+		default:
+			// Not a gzip member after all (or too big): pass the first byte
+			// through as plain text, and keep looking after it.
+			if res.err == nil {
+				g.dec.stop()
+			}
+			g.dec = nil
+			if err := g.plain(1); err != nil {
+				return len(b), err
+			}
+		}
+	}
+	return len(b), nil
+}
+
+// Flush writes any held input to the Redactor as plain text (since an
+// incomplete gzip member can't be decompressed), then flushes the Redactor.
+func (g *GzipTransparent) Flush() error {
+	if g.dec != nil {
+		g.dec.stop()
+		g.dec = nil
+	}
+	if err := g.plain(len(g.buf)); err != nil {
+		return err
+	}
+	return g.redactor.Flush()
+}
+
+// plain writes buf[:n] to the Redactor and removes it from buf.
+func (g *GzipTransparent) plain(n int) error {
+	if n == 0 {
+		return nil
+	}
+	_, err := g.redactor.Write(g.buf[:n])
+	g.buf = g.buf[n:]
+	return err
+}
+
+// member writes the redacted contents of a gzip member.
+func (g *GzipTransparent) member(data []byte) error {
+	if g.decompress {
+		_, err := g.redactor.Write(data)
+		return err
+	}
+
+	// Redact the contents separately: a secret can't span the boundary of
+	// the member. Clone shares the needles and options.
+	var redacted bytes.Buffer
+	c := g.redactor.Clone(&redacted)
+	if _, err := c.Write(data); err != nil {
+		return err
+	}
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(redacted.Bytes()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	// The member is written after the plain text before it, which the
+	// Redactor may still be holding.
+	return g.redactor.insert(compressed.Bytes())
+}
+
+// gzipDecoder decompresses a gzip member as its input is fed to it, so that a
+// member arriving in many small writes is only decoded once. The decompressor
+// runs in its own goroutine, which waits for more input between calls to
+// feed, until the member ends, turns out not to be a gzip member, or stop is
+// called.
+type gzipDecoder struct {
+	in  chan []byte
+	out chan gzipResult
+
+	// Used only by the goroutine: the input fed so far that hasn't been read,
+	// and whether the decoder has been stopped.
+	chunk   []byte
+	used    int
+	started bool
+	stopped bool
+}
+
+// gzipResult is the result of feeding input to a gzipDecoder. If neither done
+// nor err is set, the decoder needs more input.
+type gzipResult struct {
+	// If done is set, the member ended after used bytes of the input fed,
+	// and decompressed to data.
+	done bool
+	used int
+	data []byte
+
+	err error
+}
+
+// newGzipDecoder starts a gzipDecoder.
+func newGzipDecoder() *gzipDecoder {
+	d := &gzipDecoder{
+		in:  make(chan []byte),
+		out: make(chan gzipResult),
+	}
+	go d.run()
+	return d
+}
+
+// feed decompresses b, the next part of the member. b is only read until feed
+// returns.
+func (d *gzipDecoder) feed(b []byte) gzipResult {
+	d.in <- b
+	return <-d.out
+}
+
+// stop stops a decoder that needs more input.
+func (d *gzipDecoder) stop() {
+	close(d.in)
+}
+
+func (d *gzipDecoder) run() {
+	data, err := decodeGzip(d)
+	if d.stopped {
+		// Nothing is waiting for the result.
+		return
+	}
+	d.out <- gzipResult{done: err == nil, used: d.used, data: data, err: err}
+}
+
+// next waits for more input, after reporting that it is needed. It returns
+// false if the decoder is stopped instead.
+func (d *gzipDecoder) next() bool {
+	if d.started {
+		d.out <- gzipResult{}
+	}
+	d.started = true
+	chunk, ok := <-d.in
+	if !ok {
+		d.stopped = true
+		return false
+	}
+	d.chunk, d.used = chunk, 0
+	return true
+}
+
+// Read reads the input fed to the decoder, waiting for more if need be.
+func (d *gzipDecoder) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for d.used == len(d.chunk) {
+		if !d.next() {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, d.chunk[d.used:])
+	d.used += n
+	return n, nil
+}
+
+// ReadByte is like Read, for one byte. Being an io.ByteReader, the decoder is
+// read by gzip without buffering, so it doesn't read past the end of the
+// member.
+func (d *gzipDecoder) ReadByte() (byte, error) {
+	for d.used == len(d.chunk) {
+		if !d.next() {
+			return 0, io.EOF
+		}
+	}
+	c := d.chunk[d.used]
+	d.used++
+	return c, nil
+}
+
+// decodeGzip decompresses the gzip member at the start of r, up to
+// gzipMaxDecompressed bytes. r must be an io.ByteReader, so that gzip won't
+// read past the end of the member.
+func decodeGzip(r io.Reader) ([]byte, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	zr.Multistream(false)
+	data, err := io.ReadAll(io.LimitReader(zr, gzipMaxDecompressed+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > gzipMaxDecompressed {
+		return nil, errGzipTooLarge
+	}
+	return data, nil
+}
+
+// magicPrefixLen returns the length of the longest suffix of b that is a
+// proper prefix of gzipMagic.
+func magicPrefixLen(b []byte) int {
+	for n := len(gzipMagic) - 1; n > 0; n-- {
+		if bytes.HasSuffix(b, gzipMagic[:n]) {
+			return n
+		}
+	}
+	return 0
+}
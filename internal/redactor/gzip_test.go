@@ -0,0 +1,212 @@
+package redactor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func gzipString(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatalf("gzip.Writer.Write() error = %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip.Writer.Close() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// writeInPieces writes b to w in pieces of n bytes.
+func writeInPieces(w io.Writer, b []byte, n int) {
+	for len(b) > n {
+		w.Write(b[:n])
+		b = b[n:]
+	}
+	w.Write(b)
+}
+
+func newGzipTransparent(t *testing.T, r *Redactor, decompress bool) *GzipTransparent {
+	t.Helper()
+	g, err := NewGzipTransparent(r, decompress)
+	if err != nil {
+		t.Fatalf("NewGzipTransparent(r, %t) error = %v", decompress, err)
+	}
+	return g
+}
+
+func gzipTestInput(t *testing.T) []byte {
+	t.Helper()
+	var input []byte
+	input = append(input, "before secret1111 "...)
+	input = append(input, gzipString(t, "inside secret1111\n")...)
+	input = append(input, " after secret1111\n"...)
+	return input
+}
+
+func TestGzipTransparentRecompress(t *testing.T) {
+	t.Parallel()
+
+	for _, size := range []int{1, 7, 1024} {
+		var buf bytes.Buffer
+		g := newGzipTransparent(t, New(&buf, "[REDACTED]", []string{"secret1111"}), false)
+		writeInPieces(g, gzipTestInput(t), size)
+		if err := g.Flush(); err != nil {
+			t.Fatalf("g.Flush() error = %v", err)
+		}
+
+		out := buf.Bytes()
+		i := bytes.Index(out, gzipMagic)
+		if i < 0 {
+			t.Fatalf("pieces of %d: output %q contains no gzip member", size, out)
+		}
+		if got, want := string(out[:i]), "before [REDACTED] "; got != want {
+			t.Errorf("pieces of %d: plain text before member = %q, want %q", size, got, want)
+		}
+
+		br := bytes.NewReader(out[i:])
+		data, err := decodeGzip(br)
+		if err != nil {
+			t.Fatalf("pieces of %d: decodeGzip(output) error = %v", size, err)
+		}
+		n := len(out[i:]) - br.Len()
+		if got, want := string(data), "inside [REDACTED]\n"; got != want {
+			t.Errorf("pieces of %d: member contents = %q, want %q", size, got, want)
+		}
+		if got, want := string(out[i+n:]), " after [REDACTED]\n"; got != want {
+			t.Errorf("pieces of %d: plain text after member = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestGzipTransparentDecompress(t *testing.T) {
+	t.Parallel()
+
+	for _, size := range []int{1, 7, 1024} {
+		var buf strings.Builder
+		g := newGzipTransparent(t, New(&buf, "[REDACTED]", []string{"secret1111"}), true)
+		writeInPieces(g, gzipTestInput(t), size)
+		if err := g.Flush(); err != nil {
+			t.Fatalf("g.Flush() error = %v", err)
+		}
+
+		if got, want := buf.String(), "before [REDACTED] inside [REDACTED]\n after [REDACTED]\n"; got != want {
+			t.Errorf("pieces of %d: post-redaction buf.String() = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestGzipTransparentNotGzip(t *testing.T) {
+	t.Parallel()
+
+	// Looks like the start of a gzip member, but isn't one.
+	input := "\x1f\x8b\x08 not gzip secret1111 \x1f\x8b"
+
+	var buf strings.Builder
+	g := newGzipTransparent(t, New(&buf, "[REDACTED]", []string{"secret1111"}), false)
+	g.Write([]byte(input))
+	if err := g.Flush(); err != nil {
+		t.Fatalf("g.Flush() error = %v", err)
+	}
+
+	if got, want := buf.String(), "\x1f\x8b\x08 not gzip [REDACTED] \x1f\x8b"; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestGzipTransparentHeldTextBeforeMember(t *testing.T) {
+	t.Parallel()
+
+	// "secr" could be the start of a secret when the member arrives. It
+	// isn't, but is still written before the member.
+	member := gzipString(t, "inside secret1111\n")
+	var input []byte
+	input = append(input, "before secr"...)
+	input = append(input, member...)
+	input = append(input, "ecy after\n"...)
+
+	for _, size := range []int{1, 7, len(input)} {
+		var buf bytes.Buffer
+		g := newGzipTransparent(t, New(&buf, "[REDACTED]", []string{"secret1111"}), false)
+		writeInPieces(g, input, size)
+		if err := g.Flush(); err != nil {
+			t.Fatalf("pieces of %d: g.Flush() error = %v", size, err)
+		}
+
+		out := buf.Bytes()
+		i := bytes.Index(out, gzipMagic)
+		if i < 0 {
+			t.Fatalf("pieces of %d: output %q contains no gzip member", size, out)
+		}
+		if got, want := string(out[:i]), "before secr"; got != want {
+			t.Errorf("pieces of %d: plain text before member = %q, want %q", size, got, want)
+		}
+		br := bytes.NewReader(out[i:])
+		data, err := decodeGzip(br)
+		if err != nil {
+			t.Fatalf("pieces of %d: decodeGzip(output) error = %v", size, err)
+		}
+		if got, want := string(data), "inside [REDACTED]\n"; got != want {
+			t.Errorf("pieces of %d: member contents = %q, want %q", size, got, want)
+		}
+		if got, want := string(out[len(out)-br.Len():]), "ecy after\n"; got != want {
+			t.Errorf("pieces of %d: plain text after member = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestGzipTransparentTooLarge(t *testing.T) {
+	t.Parallel()
+
+	// A small member that decompresses to more than gzipMaxDecompressed.
+	var bomb bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&bomb, gzip.BestCompression)
+	if err != nil {
+		t.Fatalf("gzip.NewWriterLevel() error = %v", err)
+	}
+	zeros := make([]byte, 1<<20)
+	for i := 0; i <= gzipMaxDecompressed>>20; i++ {
+		zw.Write(zeros)
+	}
+	zw.Close()
+
+	input := append([]byte("before "), bomb.Bytes()...)
+	input = append(input, " after\n"...)
+
+	var buf bytes.Buffer
+	g := newGzipTransparent(t, New(&buf, "[REDACTED]", []string{"secret1111"}), false)
+	writeInPieces(g, input, 4096)
+	if err := g.Flush(); err != nil {
+		t.Fatalf("g.Flush() error = %v", err)
+	}
+
+	// The member is passed through unchanged.
+	if !bytes.Equal(buf.Bytes(), input) {
+		t.Errorf("output differs from input (%d bytes, want %d)", buf.Len(), len(input))
+	}
+}
+
+func TestGzipTransparentRejectsLineWriters(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		desc string
+		opt  Option
+	}{
+		{desc: "carriage return collapse", opt: WithCarriageReturnCollapse()},
+		{desc: "drop line", opt: WithPolicy(DropLine)},
+	} {
+		r := New(io.Discard, "[REDACTED]", nil, test.opt)
+		if _, err := NewGzipTransparent(r, false); err == nil {
+			t.Errorf("%s: NewGzipTransparent(r, false) error = nil, want an error", test.desc)
+		}
+		// Decompressed members are written through the redactor as text.
+		if _, err := NewGzipTransparent(r, true); err != nil {
+			t.Errorf("%s: NewGzipTransparent(r, true) error = %v", test.desc, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package redactor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// RoundTripper is an http.RoundTripper that redacts secrets from the bodies
+// of responses, for proxying logs and artifacts (for example, as the
+// Transport of an httputil.ReverseProxy). The body is redacted as it is read,
+// without being buffered in full. Since redaction changes its length, the
+// Content-Length of the response is removed, so that it is sent on chunked.
+// Only bodies with a Content-Type to redact (see ContentTypes) are redacted;
+// other responses, such as images, are returned unaltered.
+//
+// Compressed bodies can't be redacted, so requests are sent without the
+// client's Accept-Encoding header (an http.Transport then asks for gzip
+// itself, and decompresses the body transparently), and a response that still
+// has a Content-Encoding is an error.
+type RoundTripper struct {
+	base    http.RoundTripper
+	subst   string
+	needles *NeedleSet
+	types   ContentTypes
+	opts    []Option
+}
+
+// NewRoundTripper returns a RoundTripper that makes requests with base (or
+// http.DefaultTransport, if base is nil), and redacts the needles from the
+// response bodies, replacing them with subst. Each response body is redacted
+// by its own Redactor, created with the options. Bodies are redacted according
+// to DefaultContentTypes.
+func NewRoundTripper(base http.RoundTripper, subst string, needles *NeedleSet, opts ...Option) *RoundTripper {
+	return NewRoundTripperWithContentTypes(base, subst, needles, DefaultContentTypes, opts...)
+}
+
+// NewRoundTripperWithContentTypes is like NewRoundTripper, but only redacts
+// the bodies of responses with a Content-Type that types says to redact.
+func NewRoundTripperWithContentTypes(base http.RoundTripper, subst string, needles *NeedleSet, types ContentTypes, opts ...Option) *RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RoundTripper{base: base, subst: subst, needles: needles, types: types, opts: opts}
+}
+
+// RoundTrip makes the request, and returns the response with a redacting
+// body (unless its Content-Type is not to be redacted).
+func (t *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") != "" {
+		// A RoundTripper mustn't modify the request.
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if !t.types.ShouldRedact(resp.Header.Get("Content-Type")) {
+		return resp, nil
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("can't redact response body with Content-Encoding %q", ce)
+	}
+
+	resp.Body = &redactingBody{
+		Reader: NewReaderWithNeedleSet(resp.Body, t.subst, t.needles, t.opts...),
+		body:   resp.Body,
+	}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// redactingBody is a response body that reads from a Reader, and closes the
+// original body.
+type redactingBody struct {
+	*Reader
+	body io.Closer
+}
+
+func (b *redactingBody) Close() error {
+	return b.body.Close()
+}
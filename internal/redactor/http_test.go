@@ -0,0 +1,163 @@
+package redactor
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRoundTripperRedactsProxiedBody(t *testing.T) {
+	t.Parallel()
+
+	body := "log line with secret1111\n" + strings.Repeat("filler ", 10000) + "and secret1111 at the end"
+	want := "log line with [REDACTED]\n" + strings.Repeat("filler ", 10000) + "and [REDACTED] at the end"
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/length":
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			io.WriteString(w, body)
+		case "/chunked":
+			// Split the body (and a secret) between chunks.
+			i := strings.Index(body, "secret1111") + 3
+			io.WriteString(w, body[:i])
+			w.(http.Flusher).Flush()
+			io.WriteString(w, body[i:])
+		}
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error = %v", backend.URL, err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(backendURL)
+	proxy.Transport = NewRoundTripper(nil, "[REDACTED]", NewNeedleSet([]string{"secret1111"}))
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	for _, path := range []string{"/length", "/chunked"} {
+		req, err := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(GET, %s) error = %v", path, err)
+		}
+		// Ask for gzip explicitly, so the client doesn't decompress it.
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("GET %s: reading body error = %v", path, err)
+		}
+
+		if string(got) != want {
+			t.Errorf("GET %s body = %q..., want %q...", path, got[:40], want[:40])
+		}
+		if resp.ContentLength != -1 {
+			t.Errorf("GET %s resp.ContentLength = %d, want -1", path, resp.ContentLength)
+		}
+		if te := resp.TransferEncoding; len(te) != 1 || te[0] != "chunked" {
+			t.Errorf("GET %s resp.TransferEncoding = %q, want [chunked]", path, te)
+		}
+	}
+}
+
+func TestRoundTripperRejectsCompressedBody(t *testing.T) {
+	t.Parallel()
+
+	// A backend that compresses regardless of Accept-Encoding.
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, "secret1111")
+		zw.Close()
+	}))
+	defer backend.Close()
+
+	// Without DisableCompression, the base transport would decompress the
+	// body itself.
+	base := &http.Transport{DisableCompression: true}
+	defer base.CloseIdleConnections()
+	client := &http.Client{
+		Transport: NewRoundTripper(base, "[REDACTED]", NewNeedleSet([]string{"secret1111"})),
+	}
+	resp, err := client.Get(backend.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("client.Get(backend) error = nil, want an error for the compressed body")
+	}
+}
+
+func TestRoundTripperContentTypes(t *testing.T) {
+	t.Parallel()
+
+	body := "header secret1111 footer"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	needles := NewNeedleSet([]string{"secret1111"})
+	for _, test := range []struct {
+		desc, contentType string
+		types             *ContentTypes
+		want              string
+	}{
+		{desc: "text", contentType: "text/plain; charset=utf-8", want: "header [REDACTED] footer"},
+		{desc: "JSON", contentType: "application/json", want: "header [REDACTED] footer"},
+		{desc: "image", contentType: "image/png", want: body},
+		{desc: "binary", contentType: "application/octet-stream", want: body},
+		{desc: "unknown", contentType: "application/x-something", want: "header [REDACTED] footer"},
+		{
+			desc:        "custom allowed",
+			contentType: "application/octet-stream",
+			types:       &ContentTypes{Allow: []string{"application/octet-stream"}},
+			want:        "header [REDACTED] footer",
+		},
+		{
+			desc:        "custom denied",
+			contentType: "text/csv",
+			types:       &ContentTypes{Deny: []string{"text/csv"}},
+			want:        body,
+		},
+	} {
+		rt := NewRoundTripper(nil, "[REDACTED]", needles)
+		if test.types != nil {
+			rt = NewRoundTripperWithContentTypes(nil, "[REDACTED]", needles, *test.types)
+		}
+		client := &http.Client{Transport: rt}
+
+		resp, err := client.Get(backend.URL + "?type=" + url.QueryEscape(test.contentType))
+		if err != nil {
+			t.Fatalf("%s: client.Get error = %v", test.desc, err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: reading body error = %v", test.desc, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: body = %q, want %q", test.desc, got, test.want)
+		}
+		// Bodies that aren't redacted keep their length.
+		wantLength := int64(-1)
+		if test.want == body {
+			wantLength = int64(len(body))
+		}
+		if resp.ContentLength != wantLength {
+			t.Errorf("%s: resp.ContentLength = %d, want %d", test.desc, resp.ContentLength, wantLength)
+		}
+	}
+}
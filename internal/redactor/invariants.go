@@ -0,0 +1,18 @@
+package redactor
+
+import "fmt"
+
+// checkSortedByTo panics if rs is not sorted by "to", as mergeOverlaps
+// assumes. If the ranges were out of order, mergeOverlaps could fail to merge
+// overlapping ranges, and flushUpTo could write part of a secret, so it is
+// better to crash than to carry on.
+//
+// It is only called when the package is built with the redactordebug build
+// tag, since it costs a pass over the ranges on every Write.
+func checkSortedByTo(rs []subrange, caller string) {
+	for i := 1; i < len(rs); i++ {
+		if rs[i].to < rs[i-1].to {
+			panic(fmt.Sprintf("redactor: %s: completed matches not sorted by end before merging: range %d ends at %d, before range %d ending at %d", caller, i, rs[i].to, i-1, rs[i-1].to))
+		}
+	}
+}
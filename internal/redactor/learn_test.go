@@ -0,0 +1,74 @@
+package redactor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactorLearning(t *testing.T) {
+	t.Parallel()
+
+	input := "login token=hunter2hunter2:run9999 ok\n" +
+		"echo 'hunter2hunter2:run9999' and (hunter2hunter2:run9999)\n"
+
+	for _, test := range []struct {
+		desc string
+		opts []Option
+		want string
+	}{
+		{
+			desc: "without learning",
+			want: "login token=[REDACTED]:run9999 ok\n" +
+				"echo '[REDACTED]:run9999' and ([REDACTED]:run9999)\n",
+		},
+		{
+			desc: "with learning",
+			opts: []Option{WithLearning(32, 4)},
+			want: "login token=[REDACTED] ok\n" +
+				"echo '[REDACTED]' and ([REDACTED])\n",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			for _, size := range []int{1, 5, len(input)} {
+				var buf strings.Builder
+				redactor := New(&buf, "[REDACTED]", []string{"hunter2hunter2"}, test.opts...)
+				writeInPieces(redactor, []byte(input), size)
+				redactor.Flush()
+
+				if got := buf.String(); got != test.want {
+					t.Errorf("pieces of %d: post-redaction buf.String() = %q, want %q", size, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactorLearningOutlivesNeedle(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{t: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", nil, WithLearning(32, 1))
+	redactor.opts.now = clock.now
+
+	redactor.AddNeedleWithTTL("hunter2hunter2", time.Minute)
+	redactor.Write([]byte("a=hunter2hunter2:one111 b=hunter2hunter2:two222\n"))
+	clock.advance(time.Hour)
+
+	// Only the most recently learned token is remembered.
+	redactor.Write([]byte("\"hunter2hunter2:one111\" \"hunter2hunter2:two222\"\n"))
+	redactor.Flush()
+
+	want := "a=[REDACTED] b=[REDACTED]\n" +
+		"\"hunter2hunter2:one111\" \"[REDACTED]\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+	if got, want := len(redactor.learned), 1; got != want {
+		t.Errorf("len(redactor.learned) = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package redactor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// needleSetMagic and needleSetVersion begin the binary encoding of a
+// NeedleSet. The version changes whenever the encoding does.
+const (
+	needleSetMagic   = "RNS"
+	needleSetVersion = 1
+)
+
+// ErrBadNeedleSetEncoding is returned by NeedleSet.UnmarshalBinary for data
+// that isn't a NeedleSet encoded by MarshalBinary, or was encoded by an
+// incompatible version.
+var ErrBadNeedleSetEncoding = errors.New("bad needle set encoding")
+
+// MarshalBinary encodes the set, including how its needles are bucketed, so
+// that UnmarshalBinary can load it without bucketing the needles again. This
+// is faster than NewNeedleSet when loading a large set many times.
+//
+// The encoding contains the secrets themselves, unencrypted, so it is exactly
+// as sensitive as they are: store and transmit it only as carefully as the
+// secrets.
+func (set *NeedleSet) MarshalBinary() ([]byte, error) {
+	e := needleSetEncoder{}
+	e.buf.WriteString(needleSetMagic)
+	e.buf.WriteByte(needleSetVersion)
+
+	e.uvarint(uint64(set.len))
+	for c, bucket := range set.byFirstByte {
+		if len(bucket) == 0 {
+			continue
+		}
+		e.uvarint(uint64(c))
+		e.bucket(bucket)
+	}
+	// End of the first-byte buckets. Keys are at most 0xff, so this can't be
+	// confused with one.
+	e.uvarint(0x100)
+
+	keys := make([]int, 0, len(set.byFirstTwoBytes))
+	for key := range set.byFirstTwoBytes {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.uvarint(uint64(key))
+		e.bucket(set.byFirstTwoBytes[uint16(key)])
+	}
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the contents of set with a set encoded by
+// MarshalBinary. Like any NeedleSet, set must not be modified once it is in
+// use, so only unmarshal into a new NeedleSet.
+//
+// It checks that each needle is in a bucket it could be matched from, and
+// computes the fingerprints of the needles again rather than trusting the
+// data, so data that wasn't encoded by MarshalBinary is either rejected with
+// ErrBadNeedleSetEncoding or gives a set that is safe to redact with.
+func (set *NeedleSet) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(needleSetMagic)) || len(data) <= len(needleSetMagic) {
+		return ErrBadNeedleSetEncoding
+	}
+	if v := data[len(needleSetMagic)]; v != needleSetVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadNeedleSetEncoding, v)
+	}
+	d := needleSetDecoder{data: data[len(needleSetMagic)+1:]}
+
+	ns := NeedleSet{}
+	count := d.uvarint()
+	for d.err == nil {
+		c := d.uvarint()
+		if c == 0x100 {
+			break
+		}
+		if c > 0xff {
+			d.fail()
+			break
+		}
+		ns.byFirstByte[c] = d.bucket(&ns, func(n *needle) bool {
+			return n.value[0] == byte(c)
+		})
+	}
+	if keys := d.uvarint(); keys > 0 && d.err == nil {
+		ns.byFirstTwoBytes = make(map[uint16][]*needle)
+		for i := uint64(0); i < keys && d.err == nil; i++ {
+			key := d.uvarint()
+			if key > 0xffff {
+				d.fail()
+				break
+			}
+			ns.byFirstTwoBytes[uint16(key)] = d.bucket(&ns, func(n *needle) bool {
+				return len(n.value) >= 2 && n.delimiters == "" &&
+					firstTwoBytes(n.value[0], n.value[1]) == uint16(key)
+			})
+		}
+		// Only needles that can't be bucketed by first two bytes are left
+		// in the first-byte buckets.
+		for _, bucket := range ns.byFirstByte {
+			for _, n := range bucket {
+				if len(n.value) > 1 && n.delimiters == "" {
+					d.fail()
+				}
+			}
+		}
+	}
+	switch {
+	case d.err != nil:
+		return d.err
+	case len(d.data) != 0 || uint64(ns.len) != count:
+		return ErrBadNeedleSetEncoding
+	}
+	*set = ns
+	return nil
+}
+
+// needleSetEncoder encodes the parts of a NeedleSet.
+type needleSetEncoder struct {
+	buf     bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *needleSetEncoder) uvarint(x uint64) {
+	e.buf.Write(e.scratch[:binary.PutUvarint(e.scratch[:], x)])
+}
+
+func (e *needleSetEncoder) varint(x int64) {
+	e.buf.Write(e.scratch[:binary.PutVarint(e.scratch[:], x)])
+}
+
+func (e *needleSetEncoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+// bucket encodes a bucket of needles, which must all be non-empty.
+func (e *needleSetEncoder) bucket(bucket []*needle) {
+	e.uvarint(uint64(len(bucket)))
+	for _, n := range bucket {
+		e.bytes([]byte(n.value))
+		var flags uint64
+		if n.wordBoundary {
+			flags |= 1
+		}
+		if n.replacement != nil {
+			flags |= 2
+		}
+		if n.regionEnd != nil {
+			flags |= 4
+		}
+		e.uvarint(flags)
+		e.bytes(n.replacement)
+		e.varint(int64(n.priority))
+		e.uvarint(uint64(n.contextBefore))
+		e.uvarint(uint64(n.contextAfter))
+		e.bytes([]byte(n.delimiters))
+		e.bytes(n.regionEnd)
+		e.bytes([]byte(n.fingerprint))
+	}
+}
+
+// needleSetDecoder decodes the parts of a NeedleSet. After an error, it
+// returns zero values.
+type needleSetDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *needleSetDecoder) fail() {
+	if d.err == nil {
+		d.err = ErrBadNeedleSetEncoding
+	}
+	d.data = nil
+}
+
+func (d *needleSetDecoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+func (d *needleSetDecoder) varint() int64 {
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+func (d *needleSetDecoder) bytes() []byte {
+	l := d.uvarint()
+	if l > uint64(len(d.data)) {
+		d.fail()
+		return nil
+	}
+	b := d.data[:l]
+	d.data = d.data[l:]
+	return b
+}
+
+// bucket decodes a bucket of needles, adding them to the totals of set. It
+// fails if placed returns false for any of them.
+func (d *needleSetDecoder) bucket(set *NeedleSet, placed func(*needle) bool) []*needle {
+	count := d.uvarint()
+	if count > uint64(len(d.data)) {
+		// Each needle takes more than one byte, so this can't be right.
+		d.fail()
+		return nil
+	}
+	bucket := make([]*needle, 0, count)
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		n := &needle{value: string(d.bytes())}
+		flags := d.uvarint()
+		n.wordBoundary = flags&1 != 0
+		if replacement := d.bytes(); flags&2 != 0 {
+			n.replacement = append([]byte{}, replacement...)
+		}
+		n.priority = int(d.varint())
+		contextBefore, contextAfter := d.uvarint(), d.uvarint()
+		if contextBefore > math.MaxInt32 || contextAfter > math.MaxInt32 {
+			d.fail()
+			break
+		}
+		n.contextBefore, n.contextAfter = int(contextBefore), int(contextAfter)
+		n.delimiters = string(d.bytes())
+		if regionEnd := d.bytes(); flags&4 != 0 {
+			n.regionEnd = append([]byte{}, regionEnd...)
+		}
+		// The fingerprint is encoded, but is computed again, so that it
+		// can't misidentify the needle.
+		d.bytes()
+		if d.err != nil || n.value == "" || !placed(n) {
+			d.fail()
+			break
+		}
+		n.fingerprint = Fingerprint(n.value)
+
+		bucket = append(bucket, n)
+		set.count(n)
+	}
+	return bucket
+}
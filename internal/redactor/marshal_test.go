@@ -0,0 +1,241 @@
+package redactor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNeedleSetBinaryRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	skewed := append(skewedNeedles(200), "x", "Sy", "dolor")
+	for _, test := range []struct {
+		desc  string
+		set   *NeedleSet
+		input string
+	}{
+		{
+			desc:  "empty",
+			set:   NewNeedleSet(nil),
+			input: lipsum,
+		},
+		{
+			desc:  "plain",
+			set:   NewNeedleSet([]string{"ipsum", "amet"}),
+			input: lipsum,
+		},
+		{
+			desc:  "first two bytes",
+			set:   NewNeedleSet(skewed),
+			input: fmt.Sprintf("Lorem %s ipsum dolor Sy %s sit amet, x", skewed[17], skewed[199]),
+		},
+		{
+			desc: "every field",
+			set: NewNeedleSetFrom([]Needle{
+				{Value: "ipsum", WordBoundary: true, Replacement: "[IPSUM]"},
+				{Value: "dolor", Priority: -3, ContextBefore: 2, ContextAfter: 1},
+				{Value: "amet", Delimiters: "'", Replacement: ""},
+				{Value: "<<", regionEnd: []byte(">>")},
+			}),
+			input: "Lorem ipsum dolor sit 'amet' amet <<consectetur>> adipiscing",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := test.set.MarshalBinary()
+			if err != nil {
+				t.Fatalf("set.MarshalBinary() error = %v", err)
+			}
+			var got NeedleSet
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary(MarshalBinary()) error = %v", err)
+			}
+
+			if got.Len() != test.set.Len() {
+				t.Errorf("after round trip, Len() = %d, want %d", got.Len(), test.set.Len())
+			}
+			if got.regions != test.set.regions || got.maxContextBefore != test.set.maxContextBefore || got.maxLen != test.set.maxLen {
+				t.Errorf("after round trip, (regions, maxContextBefore, maxLen) = (%d, %d, %d), want (%d, %d, %d)", got.regions, got.maxContextBefore, got.maxLen, test.set.regions, test.set.maxContextBefore, test.set.maxLen)
+			}
+			if (got.byFirstTwoBytes == nil) != (test.set.byFirstTwoBytes == nil) {
+				t.Errorf("after round trip, byFirstTwoBytes == nil is %t, want %t", got.byFirstTwoBytes == nil, test.set.byFirstTwoBytes == nil)
+			}
+			// The encoding is deterministic, so encoding the same buckets
+			// again gives the same data.
+			again, err := got.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() after round trip error = %v", err)
+			}
+			if !bytes.Equal(again, data) {
+				t.Errorf("MarshalBinary() after round trip differs from the original encoding")
+			}
+
+			var want, buf strings.Builder
+			original := NewWithNeedleSet(&want, "[REDACTED]", test.set)
+			fmt.Fprint(original, test.input)
+			original.Flush()
+			redactor := NewWithNeedleSet(&buf, "[REDACTED]", &got)
+			fmt.Fprint(redactor, test.input)
+			redactor.Flush()
+			if buf.String() != want.String() {
+				t.Errorf("redacting with the round-tripped set = %q, want %q", buf.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestNeedleSetUnmarshalBinaryBadData(t *testing.T) {
+	t.Parallel()
+
+	data, err := NewNeedleSetFrom([]Needle{
+		{Value: "ipsum", Replacement: "[IPSUM]"},
+		{Value: "dolor", ContextBefore: 2},
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	bad := map[string][]byte{
+		"nil":           nil,
+		"wrong magic":   append([]byte("XYZ"), data[3:]...),
+		"wrong version": append(append([]byte(needleSetMagic), needleSetVersion+1), data[4:]...),
+		"trailing data": append(append([]byte(nil), data...), 0),
+	}
+	for i := len(needleSetMagic) + 1; i < len(data); i++ {
+		bad[fmt.Sprintf("truncated to %d bytes", i)] = data[:i]
+	}
+
+	// Sets with needles in buckets they can't be matched from, which would
+	// otherwise cause the redactor to misbehave.
+	misplaced := map[string]*NeedleSet{
+		"wrong first byte": {
+			byFirstByte: [256][]*needle{'b': {{value: "abc"}}},
+			len:         1,
+		},
+		"short needle in first two bytes": {
+			byFirstTwoBytes: map[uint16][]*needle{firstTwoBytes('a', 'b'): {{value: "a"}}},
+			len:             1,
+		},
+		"wrong first two bytes": {
+			byFirstTwoBytes: map[uint16][]*needle{firstTwoBytes('a', 'b'): {{value: "acd"}}},
+			len:             1,
+		},
+		"delimiters in first two bytes": {
+			byFirstTwoBytes: map[uint16][]*needle{firstTwoBytes('a', 'b'): {{value: "abc", delimiters: "-"}}},
+			len:             1,
+		},
+		"long needle left in first byte": {
+			byFirstByte:     [256][]*needle{'a': {{value: "abc"}}},
+			byFirstTwoBytes: map[uint16][]*needle{firstTwoBytes('x', 'y'): {{value: "xyz"}}},
+			len:             2,
+		},
+	}
+	for desc, set := range misplaced {
+		data, err := set.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: MarshalBinary() error = %v", desc, err)
+		}
+		bad[desc] = data
+	}
+	for desc, data := range bad {
+		set := NeedleSet{len: -1}
+		if err := set.UnmarshalBinary(data); !errors.Is(err, ErrBadNeedleSetEncoding) {
+			t.Errorf("%s: UnmarshalBinary() error = %v, want %v", desc, err, ErrBadNeedleSetEncoding)
+		}
+		if set.len != -1 {
+			t.Errorf("%s: UnmarshalBinary() modified the set despite the error", desc)
+		}
+	}
+}
+
+func TestNeedleSetUnmarshalBinaryFingerprint(t *testing.T) {
+	t.Parallel()
+
+	// A fingerprint in the data that doesn't match the needle is ignored.
+	data, err := (&NeedleSet{
+		byFirstByte: [256][]*needle{'i': {{value: "ipsum", fingerprint: "0badf00d"}}},
+		len:         1,
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+	var set NeedleSet
+	if err := set.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+	if got, want := set.byFirstByte['i'][0].fingerprint, Fingerprint("ipsum"); got != want {
+		t.Errorf("after UnmarshalBinary(), fingerprint = %q, want %q", got, want)
+	}
+}
+
+func FuzzNeedleSetUnmarshalBinary(f *testing.F) {
+	for _, set := range []*NeedleSet{
+		NewNeedleSet([]string{"ipsum", "amet"}),
+		NewNeedleSet(append(skewedNeedles(100), "x", "Sy", "dolor")),
+		NewNeedleSetFrom([]Needle{
+			{Value: "ipsum", WordBoundary: true, Replacement: "[IPSUM]"},
+			{Value: "dolor", Priority: -3, ContextBefore: 2, ContextAfter: 1},
+			{Value: "amet", Delimiters: "'"},
+			{Value: "<<", regionEnd: []byte(">>")},
+		}),
+	} {
+		data, err := set.MarshalBinary()
+		if err != nil {
+			f.Fatalf("MarshalBinary() error = %v", err)
+		}
+		f.Add(data, lipsum)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, input string) {
+		var set NeedleSet
+		if err := set.UnmarshalBinary(data); err != nil {
+			if !errors.Is(err, ErrBadNeedleSetEncoding) {
+				t.Errorf("UnmarshalBinary() error = %v, want %v", err, ErrBadNeedleSetEncoding)
+			}
+			return
+		}
+		// Whatever was decoded must be safe to redact with.
+		redactor := NewWithNeedleSet(io.Discard, "[REDACTED]", &set)
+		redactor.Write([]byte(input))
+		redactor.Flush()
+	})
+}
+
+func BenchmarkNeedleSetLoad(b *testing.B) {
+	needles := benchmarkNeedles(1000, 16)
+	data, err := NewNeedleSet(needles).MarshalBinary()
+	if err != nil {
+		b.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	b.Run("NewNeedleSet", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewNeedleSet(needles)
+		}
+	})
+
+	b.Run("Reset", func(b *testing.B) {
+		redactor := New(io.Discard, "[REDACTED]", nil)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			redactor.ResetSource(NeedleSlice(needles))
+			redactor.Reset(nil)
+		}
+	})
+
+	b.Run("UnmarshalBinary", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var set NeedleSet
+			if err := set.UnmarshalBinary(data); err != nil {
+				b.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package redactor
+
+// MetricsRegistry is the minimal interface needed by RegisterMetrics to export
+// redactor statistics. It is small enough to adapt to expvar (with
+// expvar.Func) or Prometheus (with GaugeFunc and CounterFunc) without this
+// package depending on either.
+type MetricsRegistry interface {
+	// Gauge registers a metric that can go up and down, whose current value
+	// is returned by f.
+	Gauge(name, help string, f func() float64)
+
+	// Counter registers a metric that only goes up, whose current value is
+	// returned by f.
+	Counter(name, help string, f func() float64)
+}
+
+// RegisterMetrics registers metrics for the redactor's Stats with the
+// registry. Each metric name begins with prefix. The metric values are read
+// from the redactor each time the registry calls the registered functions, so
+// they must not be called while holding the redactor's lock (for example,
+// from an OnRedact callback).
+func RegisterMetrics(reg MetricsRegistry, prefix string, r *Redactor) {
+	reg.Gauge(prefix+"buffered_bytes", "Number of bytes held in the redactor's buffer", func() float64 {
+		return float64(r.Stats().Buffered)
+	})
+	reg.Counter(prefix+"redactions_total", "Number of redactions written", func() float64 {
+		return float64(r.Stats().Redactions)
+	})
+	reg.Counter(prefix+"processed_bytes_total", "Number of bytes written to the redactor", func() float64 {
+		return float64(r.Stats().Processed)
+	})
+}
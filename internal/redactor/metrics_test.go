@@ -0,0 +1,67 @@
+package redactor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry is a MetricsRegistry that records the registered metrics.
+type fakeRegistry struct {
+	gauges, counters map[string]func() float64
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		gauges:   make(map[string]func() float64),
+		counters: make(map[string]func() float64),
+	}
+}
+
+func (f *fakeRegistry) Gauge(name, help string, fn func() float64) {
+	f.gauges[name] = fn
+}
+
+func (f *fakeRegistry) Counter(name, help string, fn func() float64) {
+	f.counters[name] = fn
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	t.Parallel()
+
+	reg := newFakeRegistry()
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{"ipsum", "sit"})
+	RegisterMetrics(reg, "redactor_", redactor)
+
+	buffered := reg.gauges["redactor_buffered_bytes"]
+	redactions := reg.counters["redactor_redactions_total"]
+	processed := reg.counters["redactor_processed_bytes_total"]
+	if buffered == nil || redactions == nil || processed == nil {
+		t.Fatalf("registered gauges = %v, counters = %v, want redactor_buffered_bytes, redactor_redactions_total, redactor_processed_bytes_total", reg.gauges, reg.counters)
+	}
+
+	check := func(desc string, wantBuffered, wantRedactions, wantProcessed float64) {
+		t.Helper()
+		if got := buffered(); got != wantBuffered {
+			t.Errorf("%s: buffered_bytes = %v, want %v", desc, got, wantBuffered)
+		}
+		if got := redactions(); got != wantRedactions {
+			t.Errorf("%s: redactions_total = %v, want %v", desc, got, wantRedactions)
+		}
+		if got := processed(); got != wantProcessed {
+			t.Errorf("%s: processed_bytes_total = %v, want %v", desc, got, wantProcessed)
+		}
+	}
+
+	check("before writing", 0, 0, 0)
+
+	// "Lorem ipsum dolor s" ends with a partial match of "sit", so "s" is
+	// held in the buffer.
+	fmt.Fprint(redactor, lipsum[:19])
+	check("after first write", 1, 1, 19)
+
+	fmt.Fprint(redactor, lipsum[19:])
+	redactor.Flush()
+	check("after flush", 0, 2, float64(len(lipsum)))
+}
@@ -0,0 +1,555 @@
+package redactor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrSubstContainsNeedle is returned when validating a substitution that
+// contains one of the needles it is meant to replace. Writing such a
+// substitution would leak the needle.
+var ErrSubstContainsNeedle = errors.New("substitution contains a secret to be redacted")
+
+// Needle is a secret to redact, along with options for matching it.
+type Needle struct {
+	// Value is the secret to redact.
+	Value string
+
+	// WordBoundary, if set, only redacts Value where it is not part of a
+	// larger word: the bytes either side of it must not be word bytes
+	// (letters, digits, underscore, or bytes of multibyte characters), or be
+	// the start or end of the stream. This is useful for short secrets that
+	// are also substrings of innocent words (e.g. "admin" and
+	// "administrator").
+	//
+	// Because the byte following the match must be seen before the match can
+	// be redacted, the match (and anything after it) is held back until the
+	// next byte is written, or until Flush.
+	WordBoundary bool
+
+	// Replacement, if not empty, is written instead of Value, overriding the
+	// substitution the Redactor was created with.
+	Replacement string
+
+	// Priority controls which replacement is used when matches of different
+	// needles overlap, and are redacted as a single range. The replacement of
+	// the needle with the highest priority is used. Among needles with equal
+	// priority, the longest needle wins, and after that, the match that ended
+	// first.
+	Priority int
+
+	// ContextBefore and ContextAfter, if set, widen each match of Value to
+	// also redact up to that many bytes before and after it, without
+	// crossing a line break. This is a blunt instrument for secrets that are
+	// likely to be printed near related values (e.g. an access key ID next to
+	// its secret key), so keep the windows small. See NeedleGroup.
+	//
+	// The redactor holds back up to ContextBefore bytes of the current line in
+	// case they precede a match, and holds back a match until ContextAfter
+	// more bytes (or a line break) have been written, or until Flush.
+	ContextBefore, ContextAfter int
+
+	// Delimiters, if set, are bytes that are ignored when they appear between
+	// the bytes of Value in the input, so that a secret reformatted with
+	// separators is still redacted. For example, a UUID registered without
+	// dashes, with Delimiters "-", matches it printed with or without them.
+	// The redacted range includes the delimiters. Delimiters before the first
+	// byte or after the last byte of Value are not part of the match.
+	Delimiters string
+
+	// If not nil, Value is the start marker of a region ending at regionEnd
+	// (see Redactor.AddRegion).
+	regionEnd []byte
+}
+
+// NeedleGroup returns Needles for a group of related secrets, such that when
+// any of them matches, the surrounding before and after bytes on the same line
+// are redacted too.
+func NeedleGroup(values []string, before, after int) []Needle {
+	needles := make([]Needle, 0, len(values))
+	for _, v := range values {
+		needles = append(needles, Needle{
+			Value:         v,
+			ContextBefore: before,
+			ContextAfter:  after,
+		})
+	}
+	return needles
+}
+
+// needle is the internal representation of a Needle.
+type needle struct {
+	value                       string
+	wordBoundary                bool
+	replacement                 []byte
+	priority                    int
+	contextBefore, contextAfter int
+	delimiters                  string
+	regionEnd                   []byte
+
+	// Computed when the needle is added to a set, rather than per match.
+	fingerprint string
+}
+
+// preferredOver reports whether n should provide the replacement for a range
+// covering matches of both n and m.
+func (n *needle) preferredOver(m *needle) bool {
+	switch {
+	case n == nil:
+		return false
+	case m == nil:
+		return true
+	case n.priority != m.priority:
+		return n.priority > m.priority
+	default:
+		return len(n.value) > len(m.value)
+	}
+}
+
+// Thresholds for bucketing needles by their first two bytes: there must be at
+// least twoByteMinNeedles needles, and the largest first-byte bucket must hold
+// more than 1/twoByteSkew of them.
+const (
+	twoByteMinNeedles = 64
+	twoByteSkew       = 4
+)
+
+// NeedleSet is a set of needles (secrets to redact), organised by first byte.
+// Why first byte? Because looking up needles by the first byte is a lot
+// faster than _filtering_ all the needles by first byte.
+//
+// If many needles share a first byte (e.g. lots of secrets with a common
+// prefix), most of them would land in one bucket and be tried at every
+// occurrence of that byte. In that case needles longer than one byte are
+// instead organised by their first two bytes.
+//
+// Within each bucket, needles are ordered longest first, then lexicographically
+// (and then by priority and replacement, for needles with the same value). So
+// the order in which the needles were provided never affects matching, or how
+// overlapping matches are resolved.
+//
+// A NeedleSet must not be modified after it is created, which makes it safe to
+// share between many Redactors without locking.
+type NeedleSet struct {
+	byFirstByte [256][]*needle
+
+	// If not nil, needles longer than one byte are bucketed here (keyed by
+	// their first two bytes) rather than in byFirstByte.
+	byFirstTwoBytes map[uint16][]*needle
+
+	// Number of needles in the set.
+	len int
+
+	// The largest contextBefore of any needle in the set.
+	maxContextBefore int
+
+	// The length of the longest needle in the set.
+	maxLen int
+
+	// Number of region start markers in the set.
+	regions int
+}
+
+// NeedleSource provides needle values one at a time, for example while paging
+// through a secret store, so that they need not all be held in a slice at
+// once.
+type NeedleSource interface {
+	// Each calls f with each needle value.
+	Each(f func(string))
+}
+
+// NeedleSlice is a NeedleSource that provides the values in a slice.
+type NeedleSlice []string
+
+// Each calls f with each value in the slice.
+func (s NeedleSlice) Each(f func(string)) {
+	for _, v := range s {
+		f(v)
+	}
+}
+
+// NewNeedleSet buckets the needles into a new NeedleSet. Empty needles are
+// ignored.
+func NewNeedleSet(needles []string) *NeedleSet {
+	ns := make([]Needle, 0, len(needles))
+	for _, s := range needles {
+		ns = append(ns, Needle{Value: s})
+	}
+	return NewNeedleSetFrom(ns)
+}
+
+// NewNeedleSetFrom is like NewNeedleSet, but accepts Needles with matching
+// options.
+func NewNeedleSetFrom(needles []Needle) *NeedleSet {
+	if len(needles) == 0 {
+		return emptyNeedleSet
+	}
+	set := newNeedleSet(needles)
+	if set.skewed() {
+		set.rebucketByFirstTwoBytes()
+	}
+	return set
+}
+
+// newNeedleSet buckets needles by first byte only.
+func newNeedleSet(needles []Needle) *NeedleSet {
+	set := &NeedleSet{}
+	for _, n := range needles {
+		set.add(n)
+	}
+	set.sortBuckets()
+	return set
+}
+
+// finish orders the needles in each bucket, and rebuckets them by first two
+// bytes if worthwhile. It must be called after adding needles to a new set.
+func (set *NeedleSet) finish() {
+	set.sortBuckets()
+	if set.skewed() {
+		set.rebucketByFirstTwoBytes()
+	}
+}
+
+// sortBuckets orders the needles in each first-byte bucket. Rebucketing by
+// first two bytes preserves the order.
+func (set *NeedleSet) sortBuckets() {
+	for _, bucket := range set.byFirstByte {
+		sortBucket(bucket)
+	}
+}
+
+// sortBucket orders the needles in a bucket.
+func sortBucket(bucket []*needle) {
+	sort.SliceStable(bucket, func(i, j int) bool {
+		a, b := bucket[i], bucket[j]
+		switch {
+		case len(a.value) != len(b.value):
+			return len(a.value) > len(b.value)
+		case a.value != b.value:
+			return a.value < b.value
+		case a.priority != b.priority:
+			return a.priority > b.priority
+		case a.delimiters != b.delimiters:
+			return a.delimiters < b.delimiters
+		case string(a.regionEnd) != string(b.regionEnd):
+			return string(a.regionEnd) < string(b.regionEnd)
+		default:
+			return string(a.replacement) < string(b.replacement)
+		}
+	})
+}
+
+// rebuildRatio limits how much of a NeedleSet can change for rebuild to patch
+// it: the number of needles added and removed must be no more than
+// 1/rebuildRatio of the needles in the set.
+const rebuildRatio = 4
+
+// rebuild returns a NeedleSet containing the needles, which must be distinct
+// and have no matching options, as produced by options.expandNeedles. If only
+// a few needles differ from those in set, the result is made by patching only
+// the buckets that changed (and sharing the rest with set), which is cheaper
+// than building a new set, but matches identically. Otherwise (or if set has
+// needles with matching options, or is bucketed by first two bytes) it builds
+// a new set.
+func (set *NeedleSet) rebuild(needles []Needle) *NeedleSet {
+	if set == nil || set.len == 0 || set.byFirstTwoBytes != nil {
+		return NewNeedleSetFrom(needles)
+	}
+
+	plain := true
+	set.each(func(n *needle) {
+		plain = plain && n.plain()
+	})
+	if !plain {
+		return NewNeedleSetFrom(needles)
+	}
+
+	wanted := make(map[string]bool, len(needles))
+	var added []Needle
+	for _, n := range needles {
+		wanted[n.Value] = true
+		if !inBucket(set.byFirstByte[n.Value[0]], n.Value) {
+			added = append(added, n)
+		}
+	}
+	changes := len(added)
+	var changed [256]bool
+	set.each(func(n *needle) {
+		if !wanted[n.value] {
+			changes++
+			changed[n.value[0]] = true
+		}
+	})
+	if changes*rebuildRatio > set.len {
+		return NewNeedleSetFrom(needles)
+	}
+	for _, n := range added {
+		changed[n.Value[0]] = true
+	}
+
+	// Unchanged buckets are shared with set: they are never modified.
+	ns := &NeedleSet{byFirstByte: set.byFirstByte}
+	for c, ch := range changed {
+		if !ch {
+			continue
+		}
+		var bucket []*needle
+		for _, n := range set.byFirstByte[c] {
+			if wanted[n.value] {
+				bucket = append(bucket, n)
+			}
+		}
+		ns.byFirstByte[c] = bucket
+	}
+	for _, n := range added {
+		ns.add(n)
+	}
+	for c, bucket := range ns.byFirstByte {
+		if changed[c] {
+			sortBucket(bucket)
+		}
+	}
+	// The totals so far only cover the added needles.
+	ns.recount()
+	if ns.skewed() {
+		ns.rebucketByFirstTwoBytes()
+	}
+	return ns
+}
+
+// skewed reports whether the needles are numerous and concentrated enough in
+// one first-byte bucket to be worth bucketing by first two bytes.
+func (set *NeedleSet) skewed() bool {
+	if set.len < twoByteMinNeedles {
+		return false
+	}
+	largest := 0
+	for _, bucket := range set.byFirstByte {
+		if len(bucket) > largest {
+			largest = len(bucket)
+		}
+	}
+	return largest*twoByteSkew > set.len
+}
+
+// rebucketByFirstTwoBytes moves needles longer than one byte into
+// byFirstTwoBytes. Needles with delimiters stay in byFirstByte, since a
+// delimiter could separate their first two bytes.
+func (set *NeedleSet) rebucketByFirstTwoBytes() {
+	set.byFirstTwoBytes = make(map[uint16][]*needle)
+	for c, bucket := range set.byFirstByte {
+		var short []*needle
+		for _, n := range bucket {
+			if len(n.value) == 1 || n.delimiters != "" {
+				short = append(short, n)
+				continue
+			}
+			key := firstTwoBytes(n.value[0], n.value[1])
+			set.byFirstTwoBytes[key] = append(set.byFirstTwoBytes[key], n)
+		}
+		set.byFirstByte[c] = short
+	}
+}
+
+// firstTwoBytes returns the byFirstTwoBytes key for a pair of bytes.
+func firstTwoBytes(a, b byte) uint16 {
+	return uint16(a)<<8 | uint16(b)
+}
+
+// each calls f for each needle in the set.
+func (set *NeedleSet) each(f func(*needle)) {
+	if set == nil {
+		return
+	}
+	for _, bucket := range set.byFirstByte {
+		for _, n := range bucket {
+			f(n)
+		}
+	}
+	for _, bucket := range set.byFirstTwoBytes {
+		for _, n := range bucket {
+			f(n)
+		}
+	}
+}
+
+// emptyNeedleSet is a NeedleSet with no needles. Since NeedleSets are never
+// modified, it is shared by every redactor that has no needles.
+var emptyNeedleSet = &NeedleSet{}
+
+// add adds a needle to the set. It must only be called while creating the set.
+func (set *NeedleSet) add(n Needle) {
+	if len(n.Value) == 0 {
+		return
+	}
+	nd := &needle{
+		value:         n.Value,
+		wordBoundary:  n.WordBoundary,
+		priority:      n.Priority,
+		contextBefore: n.ContextBefore,
+		contextAfter:  n.ContextAfter,
+		delimiters:    n.Delimiters,
+		regionEnd:     n.regionEnd,
+		fingerprint:   Fingerprint(n.Value),
+	}
+	if n.Replacement != "" {
+		nd.replacement = []byte(n.Replacement)
+	}
+	set.insert(nd)
+}
+
+// insert adds a needle to the byFirstByte buckets.
+func (set *NeedleSet) insert(n *needle) {
+	c := n.value[0]
+	set.byFirstByte[c] = append(set.byFirstByte[c], n)
+	set.count(n)
+}
+
+// recount recomputes the totals of the set (its length, maximums, and number of
+// regions) from the needles in its buckets.
+func (set *NeedleSet) recount() {
+	set.len, set.maxContextBefore, set.maxLen, set.regions = 0, 0, 0, 0
+	set.each(set.count)
+}
+
+// count adds n to the totals of the set.
+func (set *NeedleSet) count(n *needle) {
+	set.len++
+	if n.regionEnd != nil {
+		set.regions++
+	}
+	if n.contextBefore > set.maxContextBefore {
+		set.maxContextBefore = n.contextBefore
+	}
+	if len(n.value) > set.maxLen {
+		set.maxLen = len(n.value)
+	}
+}
+
+// learnable reports whether tokens around matches of the needle may be learned
+// (see WithLearning). Only plain needles at least RedactLengthMin long are
+// learnable.
+func (n *needle) learnable() bool {
+	return len(n.value) >= RedactLengthMin && n.plain()
+}
+
+// plain reports whether the needle has no matching options.
+func (n *needle) plain() bool {
+	return !n.wordBoundary && n.replacement == nil && n.priority == 0 &&
+		n.contextBefore == 0 && n.contextAfter == 0 && n.delimiters == "" &&
+		n.regionEnd == nil
+}
+
+// ignores reports whether c is one of the needle's delimiters.
+func (n *needle) ignores(c byte) bool {
+	return n.delimiters != "" && strings.IndexByte(n.delimiters, c) >= 0
+}
+
+// with returns a new NeedleSet containing the needles of set for which keep
+// returns true (or all of them, if keep is nil), plus the needles in add. The
+// needles themselves are shared with set, which is left unchanged.
+func (set *NeedleSet) with(keep func(*needle) bool, add []Needle) *NeedleSet {
+	ns := &NeedleSet{}
+	set.each(func(n *needle) {
+		if keep != nil && !keep(n) {
+			return
+		}
+		ns.insert(n)
+	})
+	for _, n := range add {
+		ns.add(n)
+	}
+	ns.finish()
+	return ns
+}
+
+// inBucket reports whether the bucket contains a needle with the value s.
+func inBucket(bucket []*needle, s string) bool {
+	for _, n := range bucket {
+		if n.value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// has reports whether the set contains a needle with the value s.
+func (set *NeedleSet) has(s string) bool {
+	found := false
+	set.each(func(n *needle) {
+		if n.value == s {
+			found = true
+		}
+	})
+	return found
+}
+
+// Len returns the number of needles in the set.
+func (set *NeedleSet) Len() int {
+	if set == nil {
+		return 0
+	}
+	return set.len
+}
+
+// Fingerprint returns a short, stable fingerprint of a secret: the first 8 hex
+// digits of its SHA-256 hash. It can be logged to identify which secret caused
+// a redaction (see WithOnRedact) without revealing the secret. Since it is
+// short, it identifies a secret among a few, but doesn't hide a secret that is
+// easily guessed.
+func Fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
+
+// ValidateSubst checks that neither subst nor any per-needle replacement in set
+// contains a needle in set, returning ErrSubstContainsNeedle if one does.
+func ValidateSubst(subst string, set *NeedleSet) error {
+	if set.containedIn(subst) {
+		return ErrSubstContainsNeedle
+	}
+	var err error
+	set.each(func(n *needle) {
+		if n.replacement != nil && set.containedIn(string(n.replacement)) {
+			err = ErrSubstContainsNeedle
+		}
+	})
+	return err
+}
+
+// containedIn reports whether any needle in the set occurs in s.
+func (set *NeedleSet) containedIn(s string) bool {
+	if set == nil {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		for _, n := range set.byFirstByte[s[i]] {
+			if strings.HasPrefix(s[i:], n.value) {
+				return true
+			}
+		}
+		if set.byFirstTwoBytes == nil || i+1 >= len(s) {
+			continue
+		}
+		for _, n := range set.byFirstTwoBytes[firstTwoBytes(s[i], s[i+1])] {
+			if strings.HasPrefix(s[i:], n.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWordByte reports whether c is part of a word, for the purposes of
+// Needle.WordBoundary. Bytes of multibyte UTF-8 characters are assumed to be
+// letters.
+func isWordByte(c byte) bool {
+	return c == '_' ||
+		('0' <= c && c <= '9') ||
+		('a' <= c && c <= 'z') ||
+		('A' <= c && c <= 'Z') ||
+		c >= 0x80
+}
@@ -0,0 +1,797 @@
+package redactor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewWithNeedleSetShared(t *testing.T) {
+	t.Parallel()
+
+	set := NewNeedleSet([]string{"ipsum", "amet", ""})
+	if got, want := set.Len(), 2; got != want {
+		t.Errorf("set.Len() = %d, want %d", got, want)
+	}
+
+	var bufs [3]strings.Builder
+	var mux Mux
+	for i := range bufs {
+		mux = append(mux, NewWithNeedleSet(&bufs[i], "[REDACTED]", set))
+	}
+	for _, r := range mux {
+		fmt.Fprint(r, lipsum)
+	}
+	if err := mux.Flush(); err != nil {
+		t.Fatalf("mux.Flush() = %v", err)
+	}
+
+	for i := range bufs {
+		if got, want := bufs[i].String(), "Lorem [REDACTED] dolor sit [REDACTED]"; got != want {
+			t.Errorf("post-redaction bufs[%d].String() = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestNewWithNeedleSetNil(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	r := NewWithNeedleSet(&buf, "[REDACTED]", nil)
+	fmt.Fprint(r, lipsum)
+	r.Flush()
+
+	if got, want := buf.String(), lipsum; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+const benchmarkRedactorCount = 100
+
+func BenchmarkNewPerRedactor(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < benchmarkRedactorCount; i++ {
+			New(io.Discard, "[REDACTED]", bigLipsumSecrets)
+		}
+	}
+}
+
+func BenchmarkNewWithSharedNeedleSet(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		set := NewNeedleSet(bigLipsumSecrets)
+		for i := 0; i < benchmarkRedactorCount; i++ {
+			NewWithNeedleSet(io.Discard, "[REDACTED]", set)
+		}
+	}
+}
+
+func TestRedactorWordBoundary(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			input: "admin",
+			want:  "[REDACTED]",
+		},
+		{
+			input: "user admin logged in",
+			want:  "user [REDACTED] logged in",
+		},
+		{
+			input: "user admin, administrator, sysadmin, admin_2, (admin)",
+			want:  "user [REDACTED], administrator, sysadmin, admin_2, ([REDACTED])",
+		},
+		{
+			input: "administrator",
+			want:  "administrator",
+		},
+		{
+			input: "sysadmin",
+			want:  "sysadmin",
+		},
+	}
+
+	set := NewNeedleSetFrom([]Needle{
+		{Value: "admin", WordBoundary: true},
+		{Value: "hunter2"},
+	})
+
+	for _, test := range tests {
+		test := test
+		t.Run("One write;"+test.input, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+			fmt.Fprint(redactor, test.input)
+			redactor.Flush()
+
+			if got, want := buf.String(), test.want; got != want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+			}
+		})
+
+		t.Run("Many writes;"+test.input, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+			for _, c := range []byte(test.input) {
+				redactor.Write([]byte{c})
+			}
+			redactor.Flush()
+
+			if got, want := buf.String(), test.want; got != want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRedactorWordBoundaryUnboundedNeedleUnaffected(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	redactor := NewWithNeedleSet(&buf, "[REDACTED]", NewNeedleSetFrom([]Needle{
+		{Value: "admin", WordBoundary: true},
+		{Value: "hunter2"},
+	}))
+	fmt.Fprint(redactor, "password=hunter2x admin")
+	redactor.Flush()
+
+	if got, want := buf.String(), "password=[REDACTED]x [REDACTED]"; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactorNeedlePriority(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc    string
+		needles []Needle
+		want    string
+	}{
+		{
+			desc: "Per-needle replacements",
+			needles: []Needle{
+				{Value: "ipsum", Replacement: "[TOKEN]"},
+				{Value: "amet", Replacement: "[PII]"},
+			},
+			want: "Lorem [TOKEN] dolor sit [PII]",
+		},
+		{
+			desc: "Equal priority, longest wins",
+			needles: []Needle{
+				{Value: "dolor", Replacement: "[PII]"},
+				{Value: "ipsum dolor sit", Replacement: "[TOKEN]"},
+			},
+			want: "Lorem [TOKEN] amet",
+		},
+		{
+			desc: "Equal priority and length, first to finish wins",
+			needles: []Needle{
+				{Value: "dolor sit", Replacement: "[SECOND]"},
+				{Value: "ipsum dol", Replacement: "[FIRST]"},
+			},
+			want: "Lorem [FIRST] amet",
+		},
+		{
+			desc: "Short high priority needle inside long needle",
+			needles: []Needle{
+				{Value: "dolor", Replacement: "[PII]", Priority: 1},
+				{Value: "ipsum dolor sit", Replacement: "[TOKEN]"},
+			},
+			want: "Lorem [PII] amet",
+		},
+		{
+			desc: "Short high priority needle overlapping long needle",
+			needles: []Needle{
+				{Value: "ipsum dolor", Replacement: "[TOKEN]"},
+				{Value: "or sit", Replacement: "[PII]", Priority: 1},
+			},
+			want: "Lorem [PII] amet",
+		},
+		{
+			desc: "Default substitution with high priority",
+			needles: []Needle{
+				{Value: "ipsum dolor", Replacement: "[TOKEN]"},
+				{Value: "or sit", Priority: 1},
+			},
+			want: "Lorem [REDACTED] amet",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			set := NewNeedleSetFrom(test.needles)
+
+			var buf strings.Builder
+			redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+			fmt.Fprint(redactor, lipsum)
+			redactor.Flush()
+
+			if got, want := buf.String(), test.want; got != want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateSubst(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc    string
+		subst   string
+		needles []Needle
+		want    error
+	}{
+		{
+			desc:    "No needles",
+			subst:   "[REDACTED]",
+			needles: nil,
+			want:    nil,
+		},
+		{
+			desc:    "Unrelated needles",
+			subst:   "[REDACTED]",
+			needles: []Needle{{Value: "hunter2"}, {Value: "REDACTEDX"}},
+			want:    nil,
+		},
+		{
+			desc:    "Substitution contains needle",
+			subst:   "[REDACTED]",
+			needles: []Needle{{Value: "hunter2"}, {Value: "DACT"}},
+			want:    ErrSubstContainsNeedle,
+		},
+		{
+			desc:    "Substitution is needle",
+			subst:   "[REDACTED]",
+			needles: []Needle{{Value: "[REDACTED]"}},
+			want:    ErrSubstContainsNeedle,
+		},
+		{
+			desc:    "Replacement contains needle",
+			subst:   "[REDACTED]",
+			needles: []Needle{{Value: "hunter2"}, {Value: "secret", Replacement: "[secret]"}},
+			want:    ErrSubstContainsNeedle,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			set := NewNeedleSetFrom(test.needles)
+			if got := ValidateSubst(test.subst, set); !errors.Is(got, test.want) {
+				t.Errorf("ValidateSubst(%q, set) = %v, want %v", test.subst, got, test.want)
+			}
+
+			r := NewWithNeedleSet(io.Discard, test.subst, set)
+			if got := r.Validate(); !errors.Is(got, test.want) {
+				t.Errorf("r.Validate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactorValidateOnNeedleChanges(t *testing.T) {
+	t.Parallel()
+
+	var log strings.Builder
+	r := New(io.Discard, "[REDACTED]", []string{"hunter2", "DACT"}, WithLogger(&shell.WriterLogger{Writer: &log}))
+	if got := r.Validate(); !errors.Is(got, ErrSubstContainsNeedle) {
+		t.Errorf("after New, r.Validate() = %v, want %v", got, ErrSubstContainsNeedle)
+	}
+	// Adding another needle doesn't warn again.
+	r.AddNeedle("another-secret")
+	if got, want := strings.Count(log.String(), ErrSubstContainsNeedle.Error()), 1; got != want {
+		t.Errorf("logged %d warnings about the substitution, want %d:\n%s", got, want, log.String())
+	}
+
+	r.Reset([]string{"hunter2"})
+	if got := r.Validate(); got != nil {
+		t.Errorf("after Reset without the needle, r.Validate() = %v, want nil", got)
+	}
+	r.AddNeedle("RED")
+	if got := r.Validate(); !errors.Is(got, ErrSubstContainsNeedle) {
+		t.Errorf("after AddNeedle, r.Validate() = %v, want %v", got, ErrSubstContainsNeedle)
+	}
+	r.ResetNeedleSet(NewNeedleSet([]string{"ACTED]"}))
+	if got := r.Validate(); !errors.Is(got, ErrSubstContainsNeedle) {
+		t.Errorf("after ResetNeedleSet, r.Validate() = %v, want %v", got, ErrSubstContainsNeedle)
+	}
+	if got, want := strings.Count(log.String(), ErrSubstContainsNeedle.Error()), 2; got != want {
+		t.Errorf("logged %d warnings about the substitution, want %d:\n%s", got, want, log.String())
+	}
+}
+
+// skewedNeedles returns n needles that all begin with the same byte.
+func skewedNeedles(n int) []string {
+	needles := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		needles = append(needles, fmt.Sprintf("S%x-secret-%d", i*7919, i))
+	}
+	return needles
+}
+
+func TestNeedleSetFirstTwoBytes(t *testing.T) {
+	t.Parallel()
+
+	needles := append(skewedNeedles(200), "x", "Sy", "dolor")
+	set := NewNeedleSet(needles)
+	if set.byFirstTwoBytes == nil {
+		t.Fatalf("NewNeedleSet(skewed needles).byFirstTwoBytes = nil, want non-nil")
+	}
+	if NewNeedleSet(bigLipsumSecrets).byFirstTwoBytes != nil {
+		t.Errorf("NewNeedleSet(bigLipsumSecrets).byFirstTwoBytes != nil, want nil")
+	}
+
+	input := fmt.Sprintf("Lorem %s ipsum dolor Sy %s sit amet, S%s x", needles[17], needles[199], needles[3])
+	want := "Lorem [REDACTED] ipsum [REDACTED] [REDACTED] [REDACTED] sit amet, S[REDACTED] [REDACTED]"
+
+	t.Run("One write", func(t *testing.T) {
+		t.Parallel()
+
+		var buf strings.Builder
+		redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+		fmt.Fprint(redactor, input)
+		redactor.Flush()
+
+		if got := buf.String(); got != want {
+			t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Many writes", func(t *testing.T) {
+		t.Parallel()
+
+		var buf strings.Builder
+		redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+		for _, c := range []byte(input) {
+			redactor.Write([]byte{c})
+		}
+		redactor.Flush()
+
+		if got := buf.String(); got != want {
+			t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+		}
+	})
+
+	if err := ValidateSubst("[REDACTED] Sy", set); !errors.Is(err, ErrSubstContainsNeedle) {
+		t.Errorf("ValidateSubst(%q, set) = %v, want %v", "[REDACTED] Sy", err, ErrSubstContainsNeedle)
+	}
+}
+
+func BenchmarkSkewedNeedles(b *testing.B) {
+	needles := skewedNeedles(1000)
+	ns := make([]Needle, 0, len(needles))
+	for _, s := range needles {
+		ns = append(ns, Needle{Value: s})
+	}
+	input := strings.Repeat("Some Sample Sentence Starting So Similarly. ", 100)
+
+	for name, set := range map[string]*NeedleSet{
+		"first byte":      newNeedleSet(ns),
+		"first two bytes": NewNeedleSetFrom(ns),
+	} {
+		set := set
+		b.Run(name, func(b *testing.B) {
+			r := NewWithNeedleSet(io.Discard, "[REDACTED]", set)
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				fmt.Fprint(r, input)
+			}
+			r.Flush()
+		})
+	}
+}
+
+func TestRedactorNeedleGroupContext(t *testing.T) {
+	t.Parallel()
+
+	group := NeedleGroup([]string{"SECRET1234", "KEYID5678"}, 5, 4)
+
+	for _, test := range []struct {
+		desc    string
+		needles []Needle
+		input   string
+		want    string
+	}{
+		{
+			desc:    "window either side",
+			needles: group,
+			input:   "abcdefghij SECRET1234 klmnopqrst\n",
+			want:    "abcdef[REDACTED]nopqrst\n",
+		},
+		{
+			desc:    "any member of the group",
+			needles: group,
+			input:   "abcdefghij KEYID5678 klmnopqrst\n",
+			want:    "abcdef[REDACTED]nopqrst\n",
+		},
+		{
+			desc:    "clamped to the line",
+			needles: group,
+			input:   "ab\nSECRET1234\ncd\n",
+			want:    "ab\n[REDACTED]\ncd\n",
+		},
+		{
+			desc:    "clamped to the stream",
+			needles: group,
+			input:   "a SECRET1234 b",
+			want:    "[REDACTED]",
+		},
+		{
+			desc:    "window overlapping another match",
+			needles: append([]Needle{{Value: "lmnopq"}}, group...),
+			input:   "abcdefghij SECRET1234 klmnopqrst\n",
+			want:    "abcdef[REDACTED]rst\n",
+		},
+		{
+			desc:    "no match, no window",
+			needles: group,
+			input:   "abcdefghij SECRET123 klmnopqrst\n",
+			want:    "abcdefghij SECRET123 klmnopqrst\n",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			set := NewNeedleSetFrom(test.needles)
+			for _, size := range []int{1, 3, len(test.input)} {
+				var buf strings.Builder
+				redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+				writeInPieces(redactor, []byte(test.input), size)
+				redactor.Flush()
+
+				if got := buf.String(); got != test.want {
+					t.Errorf("pieces of %d: post-redaction buf.String() = %q, want %q", size, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNeedleSetOrderIndependent(t *testing.T) {
+	t.Parallel()
+
+	needles := []Needle{
+		{Value: "secret", Replacement: "[A]"},
+		{Value: "secret", Replacement: "[B]"},
+		{Value: "secret1234"},
+		{Value: "sec"},
+		{Value: "security", Priority: 1},
+		{Value: "cret12"},
+	}
+	input := "secret1234 secret security secre sec\n"
+
+	var want string
+	for i := 0; i < 20; i++ {
+		ns := append([]Needle(nil), needles...)
+		rand.New(rand.NewSource(int64(i))).Shuffle(len(ns), func(i, j int) {
+			ns[i], ns[j] = ns[j], ns[i]
+		})
+		set := NewNeedleSetFrom(ns)
+
+		var values []string
+		for _, n := range set.byFirstByte['s'] {
+			values = append(values, n.value+string(n.replacement))
+		}
+		if diff := cmp.Diff(values, []string{"secret1234", "security", "secret[A]", "secret[B]", "sec"}); diff != "" {
+			t.Errorf("set.byFirstByte['s'] values diff (-got +want):\n%s", diff)
+		}
+
+		var buf strings.Builder
+		redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+		redactor.Write([]byte(input))
+		redactor.Flush()
+
+		if i == 0 {
+			want = buf.String()
+			continue
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("with needles in order %v: post-redaction buf.String() = %q, want %q", ns, got, want)
+		}
+	}
+}
+
+func TestNeedleSetRebuild(t *testing.T) {
+	t.Parallel()
+
+	base := benchmarkNeedles(200, 12)
+	input := benchmarkInput(8192, append(base, "added-needle-1", "added-needle-2"))
+
+	for _, test := range []struct {
+		desc    string
+		needles []string
+	}{
+		{desc: "unchanged", needles: base},
+		{desc: "one added", needles: append(append([]string(nil), base...), "added-needle-1")},
+		{desc: "one removed", needles: base[1:]},
+		{desc: "some added and removed", needles: append(append([]string(nil), base[10:]...), "added-needle-1", "added-needle-2")},
+		{desc: "mostly changed", needles: append(append([]string(nil), base[150:]...), "added-needle-1")},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var patched, fresh strings.Builder
+			patchedRedactor := New(&patched, "[REDACTED]", base)
+			patchedRedactor.Reset(test.needles)
+			freshRedactor := New(&fresh, "[REDACTED]", test.needles)
+
+			if got, want := patchedRedactor.needles.Len(), freshRedactor.needles.Len(); got != want {
+				t.Errorf("after Reset: needles.Len() = %d, want %d", got, want)
+			}
+			for c := range freshRedactor.needles.byFirstByte {
+				var got, want []string
+				for _, n := range patchedRedactor.needles.byFirstByte[c] {
+					got = append(got, n.value)
+				}
+				for _, n := range freshRedactor.needles.byFirstByte[c] {
+					want = append(want, n.value)
+				}
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Errorf("after Reset: byFirstByte[%q] diff (-got +want):\n%s", c, diff)
+				}
+			}
+
+			patchedRedactor.Write(input)
+			patchedRedactor.Flush()
+			freshRedactor.Write(input)
+			freshRedactor.Flush()
+			if got, want := patched.String(), fresh.String(); got != want {
+				t.Errorf("after Reset: output differs from a new redactor")
+			}
+		})
+	}
+}
+
+func TestNeedleSetRebuildTotals(t *testing.T) {
+	t.Parallel()
+
+	// A set large enough for rebuild to patch, whose longest needle is much
+	// longer than the rest.
+	base := benchmarkNeedles(200, 12)
+	base = append(base, "a-much-longer-needle-than-all-the-others")
+
+	for _, test := range []struct {
+		desc    string
+		needles []string
+	}{
+		{desc: "one added", needles: append(append([]string(nil), base...), "added-needle-1")},
+		{desc: "one removed", needles: base[1:]},
+		{desc: "longest removed", needles: base[:len(base)-1]},
+		{desc: "longer added", needles: append(append([]string(nil), base...), "an-even-longer-needle-than-the-much-longer-one")},
+	} {
+		var needles []Needle
+		for _, v := range test.needles {
+			needles = append(needles, Needle{Value: v})
+		}
+		got := NewNeedleSet(base).rebuild(needles)
+		want := NewNeedleSetFrom(needles)
+
+		if got.len != want.len {
+			t.Errorf("%s: rebuild().len = %d, want %d", test.desc, got.len, want.len)
+		}
+		if got.maxLen != want.maxLen {
+			t.Errorf("%s: rebuild().maxLen = %d, want %d", test.desc, got.maxLen, want.maxLen)
+		}
+		if got.maxContextBefore != want.maxContextBefore {
+			t.Errorf("%s: rebuild().maxContextBefore = %d, want %d", test.desc, got.maxContextBefore, want.maxContextBefore)
+		}
+		if got.regions != want.regions {
+			t.Errorf("%s: rebuild().regions = %d, want %d", test.desc, got.regions, want.regions)
+		}
+		if (got.byFirstTwoBytes == nil) != (want.byFirstTwoBytes == nil) {
+			t.Errorf("%s: rebuild() bucketed by first two bytes = %t, want %t", test.desc, got.byFirstTwoBytes != nil, want.byFirstTwoBytes != nil)
+		}
+		for c := range want.byFirstByte {
+			if diff := cmp.Diff(bucketValues(got.byFirstByte[c]), bucketValues(want.byFirstByte[c])); diff != "" {
+				t.Errorf("%s: rebuild().byFirstByte[%q] diff (-got +want):\n%s", test.desc, c, diff)
+			}
+		}
+		for key, bucket := range want.byFirstTwoBytes {
+			if diff := cmp.Diff(bucketValues(got.byFirstTwoBytes[key]), bucketValues(bucket)); diff != "" {
+				t.Errorf("%s: rebuild().byFirstTwoBytes[%#04x] diff (-got +want):\n%s", test.desc, key, diff)
+			}
+		}
+	}
+}
+
+// bucketValues returns the values of the needles in a bucket.
+func bucketValues(bucket []*needle) []string {
+	var values []string
+	for _, n := range bucket {
+		values = append(values, n.value)
+	}
+	return values
+}
+
+func BenchmarkReset(b *testing.B) {
+	needles := benchmarkNeedles(1000, 16)
+	changed := append(append([]string(nil), needles[1:]...), "a-changed-needle")
+
+	b.Run("one changed", func(b *testing.B) {
+		redactor := New(io.Discard, "[REDACTED]", needles)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if i%2 == 0 {
+				redactor.Reset(changed)
+			} else {
+				redactor.Reset(needles)
+			}
+		}
+	})
+
+	b.Run("full rebuild", func(b *testing.B) {
+		redactor := New(io.Discard, "[REDACTED]", needles)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if i%2 == 0 {
+				redactor.ResetSource(NeedleSlice(changed))
+			} else {
+				redactor.ResetSource(NeedleSlice(needles))
+			}
+		}
+	})
+}
+
+func BenchmarkResetEmptyToEmpty(b *testing.B) {
+	redactor := New(io.Discard, "[REDACTED]", nil)
+	mux := Mux{redactor}
+	reset := func() {
+		redactor.Reset(nil)
+		redactor.Reset([]string{})
+		mux.Reset(nil)
+	}
+	if allocs := testing.AllocsPerRun(10, reset); allocs != 0 {
+		b.Errorf("Reset from no needles to no needles allocated %v times per run, want 0", allocs)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reset()
+	}
+}
+
+func TestRedactorReplacementStraddlingFlushLimit(t *testing.T) {
+	t.Parallel()
+
+	// A match of "secret1234" straddles the flush limit, because "1234xyz"
+	// has partially matched from its "1234". Its replacement must be written
+	// whole, exactly once, whether or not the partial match completes.
+	set := NewNeedleSetFrom([]Needle{
+		{Value: "secret1234", Replacement: "**********"},
+		{Value: "1234xyz", Replacement: "#######"},
+	})
+
+	for _, test := range []struct {
+		desc   string
+		writes []string
+		want   string
+	}{
+		{
+			desc:   "partial match fails",
+			writes: []string{"a secret1234xy", "q b\n"},
+			want:   "a **********xyq b\n",
+		},
+		{
+			desc:   "partial match completes",
+			writes: []string{"a secret1234xy", "z b\n"},
+			want:   "a ********** b\n",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := NewWithNeedleSet(&buf, "[REDACTED]", set)
+			redactor.Write([]byte(test.writes[0]))
+			if got, want := buf.String(), "a **********"; got != want {
+				t.Errorf("after first Write: buf.String() = %q, want %q", got, want)
+			}
+			redactor.Write([]byte(test.writes[1]))
+			redactor.Flush()
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	if got, want := Fingerprint("hunter2"), "f52fbd32"; got != want {
+		t.Errorf("Fingerprint(hunter2) = %q, want %q", got, want)
+	}
+}
+
+func TestRedactorOnRedactFingerprint(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{"secret1111", "secret2222", "secret3333"},
+		WithOnRedact(func(r Redaction) { got = append(got, r.Fingerprint) }),
+	)
+	redactor.Write([]byte("secret2222 then secret3333, then secret2222 again\n"))
+	redactor.Flush()
+
+	want := []string{Fingerprint("secret2222"), Fingerprint("secret3333"), Fingerprint("secret2222")}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("OnRedact fingerprints diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestRedactorNeedleDelimiters(t *testing.T) {
+	t.Parallel()
+
+	const uuid = "550e8400e29b41d4a716446655440000"
+	needles := NewNeedleSetFrom([]Needle{{Value: uuid, Delimiters: "-:"}})
+
+	for _, test := range []struct {
+		desc, input, want string
+	}{
+		{
+			desc:  "dashless",
+			input: "id=550e8400e29b41d4a716446655440000\n",
+			want:  "id=[REDACTED]\n",
+		},
+		{
+			desc:  "dashed",
+			input: "id=550e8400-e29b-41d4-a716-446655440000\n",
+			want:  "id=[REDACTED]\n",
+		},
+		{
+			desc:  "colons",
+			input: "id=550e8400:e29b:41d4:a716:446655440000\n",
+			want:  "id=[REDACTED]\n",
+		},
+		{
+			desc:  "delimiters outside the match are kept",
+			input: "id=-550e8400-e29b-41d4-a716-446655440000-\n",
+			want:  "id=-[REDACTED]-\n",
+		},
+		{
+			desc:  "other separators don't match",
+			input: "id=550e8400_e29b_41d4_a716_446655440000\n",
+			want:  "id=550e8400_e29b_41d4_a716_446655440000\n",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf strings.Builder
+			redactor := NewWithNeedleSet(&buf, "[REDACTED]", needles)
+			writeInPieces(redactor, []byte(test.input), 5)
+			redactor.Flush()
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !redactordebug
+
+package redactor
+
+// debugInvariants enables checks of internal invariants that are too costly
+// for production builds. Build with -tags redactordebug to enable them.
+const debugInvariants = false
@@ -0,0 +1,472 @@
+package redactor
+
+import (
+	"hash"
+	"io"
+	"time"
+
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/buildkite/agent/v3/internal/redactor/ranges"
+)
+
+// Option configures optional behaviour of a Redactor.
+type Option func(*options)
+
+// options holds the optional behaviour of a Redactor.
+type options struct {
+	dryRun          bool
+	policy          Policy
+	onRedact        func(Redaction)
+	onOffsetMapping func(OffsetMapping)
+	onMatches       func([]ranges.Range)
+	mergeAdjacent   bool
+	mergeGap        int
+	collapseRepeats int
+	syncMaxAge      int
+	collapseCR      bool
+	framedWrites    bool
+	stallTimeout    time.Duration
+	idleFlush       time.Duration
+	learnWindow     int
+	learnMax        int
+	bufferSize      int
+	unwrapColumn    int
+
+	firstOccurrenceOnly bool
+	surroundingQuotes   bool
+	unterminatedRegions UnterminatedRegionPolicy
+	substFunc           func(MatchMeta) []byte
+	sidecar             io.Writer
+	hash                hash.Hash
+	name                string
+	logger              shell.Logger
+
+	// The clock and timers, which tests may replace. New sets them to
+	// time.Now and (a wrapper of) time.AfterFunc.
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+
+	// Options for deriving extra needles from each secret.
+	normalizeUnicode bool
+	htmlEscape       bool
+	jsonByteArray    bool
+	quotedPrintable  bool
+	reversed         bool
+}
+
+// WithDryRun puts the redactor into dry-run mode. In dry-run mode the redactor
+// writes its input to the destination unaltered, but otherwise behaves as
+// though it were redacting: the redaction count in Stats is incremented and
+// the OnRedact callback is called for each range that would be redacted.
+// This is useful for estimating how much output would be redacted before
+// enforcing redaction.
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// Policy is how a redactor handles the secrets it finds. Giving each redactor
+// in a Mux its own policy lets the same secrets (set by Mux.Reset) be handled
+// differently for each destination.
+type Policy int
+
+const (
+	// Redact replaces secrets with the substitution. This is the default.
+	Redact Policy = iota
+
+	// PassThrough writes secrets unaltered, as in dry-run mode (see
+	// WithDryRun). Use it only for destinations that are as private as the
+	// secrets themselves.
+	PassThrough
+
+	// DropLine discards each line of output containing a secret, including
+	// its line feed, instead of writing the substitution. Lines are held by
+	// the redactor until they end (or are longer than 64 KiB, in which case
+	// the start of the line is written, and only the rest is discarded), so
+	// it is best suited to line-oriented output. Offsets reported by
+	// WithOffsetMapping and WithSidecar don't account for dropped lines.
+	DropLine
+)
+
+// WithPolicy sets how the redactor handles the secrets it finds.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// passSecrets reports whether secrets are written unaltered, because of
+// WithDryRun or the PassThrough policy.
+func (o *options) passSecrets() bool {
+	return o.dryRun || o.policy == PassThrough
+}
+
+// WithOnRedact sets a callback that is called each time a range of the input
+// is redacted. The callback is called while the redactor is locked, so it must
+// not call methods on the redactor.
+func WithOnRedact(f func(Redaction)) Option {
+	return func(o *options) {
+		o.onRedact = f
+	}
+}
+
+// WithAdjacentMerge causes secrets that are immediately adjacent to one
+// another (e.g. "tokenAtokenB") to be redacted with a single substitution,
+// rather than one each. Without this option, only secrets that overlap are
+// redacted as one.
+//
+// Because a secret at the end of the input could be followed by another,
+// the redactor holds back the final secret of each Write until it sees the
+// following byte (or Flush is called).
+func WithAdjacentMerge() Option {
+	return func(o *options) {
+		o.mergeAdjacent = true
+	}
+}
+
+// WithMergeGap causes secrets separated by no more than gap bytes (e.g.
+// "tokenA:tokenB", with a gap of 1) to be redacted with a single substitution
+// that also covers the bytes between them, so that the separator doesn't
+// reveal the structure of the secrets. This redacts bytes that are not part of
+// any secret, so keep gap small. A gap of 0 or less disables gap merging
+// (adjacent secrets are still merged by WithAdjacentMerge).
+//
+// Like WithAdjacentMerge, the redactor holds back secrets near the end of the
+// input until it has seen enough following bytes (or Flush is called).
+func WithMergeGap(gap int) Option {
+	return func(o *options) {
+		if gap < 0 {
+			gap = 0
+		}
+		o.mergeGap = gap
+	}
+}
+
+// WithCollapseRepeats causes runs of at least threshold identical
+// substitutions, separated only by whitespace, to be written as a single
+// substitution with a repeat count. For example, a secret logged on five
+// consecutive lines is written as "[REDACTED ×5]" (the count is placed
+// inside a trailing ']' if the substitution has one, otherwise appended).
+// The whitespace between the substitutions is not written. A threshold less
+// than 2 disables collapsing.
+//
+// Because a run could continue in the next Write, the redactor holds back a
+// run of substitutions at the end of the input until it ends (or Flush is
+// called).
+func WithCollapseRepeats(threshold int) Option {
+	return func(o *options) {
+		if threshold < 2 {
+			threshold = 0
+		}
+		o.collapseRepeats = threshold
+	}
+}
+
+// WithOffsetMapping sets a callback that is called each time a substitution is
+// written, with the position of the redacted range in the input and of the
+// substitution in the output. This can be used to build a table translating
+// offsets in the original stream to offsets in the redacted stream. Like
+// OnRedact, the callback is called while the redactor is locked.
+func WithOffsetMapping(f func(OffsetMapping)) Option {
+	return func(o *options) {
+		o.onOffsetMapping = f
+	}
+}
+
+// WithOnMatches sets a callback that is called with the individual ranges of
+// the input stream to be redacted, before overlapping (or adjacent) ranges are
+// merged into the ranges that are actually substituted, as reported to
+// WithOnRedact. Each range is the match of one needle, widened by any context
+// window. Offsets are positions in the input stream. The callback may be
+// called several times per Write, with the ranges completed since the last
+// call, in order of where they end. The slice is not reused, so the callback
+// may keep it. Like OnRedact, the callback is called while the redactor is
+// locked.
+func WithOnMatches(f func([]ranges.Range)) Option {
+	return func(o *options) {
+		o.onMatches = f
+	}
+}
+
+// WithSyncMaxAge causes Sync to abandon partial matches that began at least
+// maxAge Writes ago. See Sync.
+func WithSyncMaxAge(maxAge int) Option {
+	return func(o *options) {
+		o.syncMaxAge = maxAge
+	}
+}
+
+// WithUnicodeNormalization causes the redactor to also redact the NFC and NFD
+// Unicode normalization forms of each secret passed to New or Reset, if they
+// differ from the secret. A secret copied through an editor or terminal may be
+// renormalized, so it renders the same but has different bytes. Secrets that
+// are entirely ASCII are unaffected by normalization, and are skipped.
+func WithUnicodeNormalization() Option {
+	return func(o *options) {
+		o.normalizeUnicode = true
+	}
+}
+
+// WithHTMLEscapedNeedles causes the redactor to also redact the HTML-escaped
+// form of each secret passed to New or Reset, as produced by html.EscapeString,
+// if it differs from the secret. A secret rendered into HTML (an annotation,
+// say) has its special characters replaced by entities such as "&amp;" and
+// "&#39;", so would otherwise evade redaction.
+func WithHTMLEscapedNeedles() Option {
+	return func(o *options) {
+		o.htmlEscape = true
+	}
+}
+
+// WithJSONByteArrayNeedles causes the redactor to also redact each secret
+// passed to New or Reset rendered as a JSON array of its byte values, such as
+// [104,117,110,116,101,114], both compact and with a space after each comma.
+// Some serializers render byte slices this way, rather than as strings, which
+// would otherwise evade redaction.
+func WithJSONByteArrayNeedles() Option {
+	return func(o *options) {
+		o.jsonByteArray = true
+	}
+}
+
+// WithQuotedPrintableNeedles causes the redactor to also redact each secret
+// passed to New or Reset in quoted-printable encoding (RFC 2045), as found in
+// email and other MIME content, such as "pa=3Dss" for "pa=ss". A soft line
+// break ("=" followed by a line break) may split the encoded secret anywhere,
+// so these are skipped within a match (see Needle.Delimiters).
+func WithQuotedPrintableNeedles() Option {
+	return func(o *options) {
+		o.quotedPrintable = true
+	}
+}
+
+// WithReversedNeedles causes the redactor to also redact each secret passed to
+// New or Reset reversed, as printed by piping it through rev(1), a known trick
+// for getting a secret past redaction. Secrets are reversed byte by byte, and
+// also character by character if they aren't entirely ASCII. It is off by
+// default, since it doubles the number of needles.
+func WithReversedNeedles() Option {
+	return func(o *options) {
+		o.reversed = true
+	}
+}
+
+// WithSurroundingQuotes causes a secret immediately surrounded by a pair of
+// double or single quotes, as in a YAML, TOML, or JSON config file (e.g.
+// password: "hunter2"), to be redacted together with the quotes, so that it is
+// written as [REDACTED] rather than "[REDACTED]". Unmatched quotes are left
+// alone. A quote is only redacted if the redactor still holds it, so the
+// redactor holds back a quote at the end of each Write until it sees the
+// following byte (or Flush is called).
+func WithSurroundingQuotes() Option {
+	return func(o *options) {
+		o.surroundingQuotes = true
+	}
+}
+
+// WithUnwrapColumn causes the redactor to match secrets that have been
+// hard-wrapped at column (as some terminals and loggers do, breaking lines
+// every column bytes, even mid-word): a line feed within a match is skipped
+// over if it ends a line exactly column bytes long. The redacted range
+// includes the line feeds. Columns are counted in bytes, so lines with
+// multibyte characters are only unwrapped if they were wrapped by bytes too.
+func WithUnwrapColumn(column int) Option {
+	return func(o *options) {
+		o.unwrapColumn = column
+	}
+}
+
+// WithCarriageReturnCollapse removes text that is overwritten by a carriage
+// return from the output. Programs drawing progress bars (and the like) print
+// a carriage return ("\r", not followed by "\n") to return the cursor to the
+// start of the line and overwrite it. A terminal would hide the overwritten
+// text, but a log sink that doesn't interpret carriage returns would keep it.
+// With this option, the redactor discards the current line of output whenever
+// it sees a bare carriage return, and holds each line of output until it is
+// complete (or Flush is called).
+//
+// Matching is unaffected: secrets are matched against the original input,
+// including any carriage returns. Offsets reported to WithOffsetMapping do not
+// account for text removed by this option.
+func WithCarriageReturnCollapse() Option {
+	return func(o *options) {
+		o.collapseCR = true
+	}
+}
+
+// WithFramedWrites causes every Write to behave like WriteLine: each Write is
+// treated as a complete message, so secrets are not matched across Writes, and
+// nothing is left buffered after each Write (except for the start of a secret
+// spanning lines, as described for WriteLine). Only use this if each Write
+// always contains whole secrets (for example, a logger that writes one
+// message per call).
+func WithFramedWrites() Option {
+	return func(o *options) {
+		o.framedWrites = true
+	}
+}
+
+// WithStallTimeout causes Write to return an error wrapping ErrStalled if data
+// has been buffered for longer than d without any of it being written out,
+// for example because input keeps extending a partial match. The data is not
+// discarded: the caller can decide to call Flush (or Sync, with
+// WithSyncMaxAge) to force it out, or abandon the stream. The timer restarts
+// each time buffered data is written.
+func WithStallTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.stallTimeout = d
+	}
+}
+
+// WithIdleFlush causes the redactor to write out what it can once no Write
+// has arrived for d, rather than wait for the next Write, to bound the latency
+// of interactive output. This is like calling Sync: the start of a possible
+// secret is still held back, unless the redactor was created with
+// WithSyncMaxAge, in which case the idle period counts as a Write towards the
+// age of partial matches (so with a maxAge of 1, all of them are abandoned
+// once the output is idle). Redactions held back in case they are merged
+// (WithAdjacentMerge, WithMergeGap) or collapsed (WithCollapseRepeats) with
+// later ones are written too.
+//
+// If the destination returns an error, the output it didn't accept is kept,
+// and written before any other output by the next Write, Sync, or Flush (see
+// Write).
+func WithIdleFlush(d time.Duration) Option {
+	return func(o *options) {
+		o.idleFlush = d
+	}
+}
+
+// WithLearning enables a speculative mode for catching secrets that are only
+// known at runtime, such as a token formed from a known secret and a runtime
+// value. Whenever a needle matches, the whole token around the match (up to
+// window bytes either side, ending at whitespace, quotes, brackets, or other
+// delimiters) is redacted, and learned as a new needle. So if the token is
+// later echoed with different delimiters, all of it is redacted again, even
+// if the original needle has since been removed (by AddNeedleWithTTL, say).
+//
+// At most maxLearned tokens are learned: after that, learning a new token forgets
+// the oldest. Only needles at least RedactLengthMin long, with no other
+// matching options, are used for learning. Reset forgets all learned tokens.
+// A window or maxLearned less than 1 disables learning.
+func WithLearning(window, maxLearned int) Option {
+	return func(o *options) {
+		if window < 1 || maxLearned < 1 {
+			window, maxLearned = 0, 0
+		}
+		o.learnWindow = window
+		o.learnMax = maxLearned
+	}
+}
+
+// WithBufferedOutput buffers the redacted output in a buffer of the given size,
+// so that it is written to the destination in fewer, larger writes, rather
+// than once per unredacted segment and substitution. The buffer is written
+// out at the end of each Write, Sync, and Flush (and whenever it fills), so no
+// output is delayed beyond the call that produced it. A size less than 1
+// disables buffering.
+func WithBufferedOutput(size int) Option {
+	return func(o *options) {
+		o.bufferSize = size
+	}
+}
+
+// WithFirstOccurrenceOnly causes only the first occurrence of each secret
+// since the redactor was created (or last Reset) to be redacted. Later
+// occurrences of the same secret are written unaltered.
+//
+// WARNING: this deliberately leaks secrets. It is only for output where
+// knowing that a secret was present matters more than hiding it, and that is
+// itself protected at least as well as the secrets (e.g. an internal report).
+// Never use it for job logs or anything else that is widely readable.
+func WithFirstOccurrenceOnly() Option {
+	return func(o *options) {
+		o.firstOccurrenceOnly = true
+	}
+}
+
+// UnterminatedRegionPolicy is how a region (see Redactor.AddRegion) that is
+// still open when the stream is flushed is written.
+type UnterminatedRegionPolicy int
+
+const (
+	// RedactUnterminatedRegions redacts an unterminated region from its start
+	// marker to the end of the stream. This is the default.
+	RedactUnterminatedRegions UnterminatedRegionPolicy = iota
+
+	// PassUnterminatedRegions writes an unterminated region, including its
+	// start marker, unaltered (except for any needles within it). Use this
+	// only if a stray start marker is more likely than a missing end marker.
+	PassUnterminatedRegions
+)
+
+// WithUnterminatedRegions sets how regions that are still open when the
+// redactor is flushed are written.
+func WithUnterminatedRegions(policy UnterminatedRegionPolicy) Option {
+	return func(o *options) {
+		o.unterminatedRegions = policy
+	}
+}
+
+// WithSubstFunc sets a function that returns the substitution for each
+// redacted range, instead of the substitution the redactor was created with
+// (needles with their own Replacement still use it). For example, it could
+// return "[REDACTED:len=40]" for a 40 byte range.
+//
+// The function must not return anything derived from the secret beyond what
+// MatchMeta provides, or it could leak it. It is called while the redactor is
+// locked, possibly more than once per range, so it must be fast, must return
+// the same substitution for the same MatchMeta, and must not call methods on
+// the redactor.
+func WithSubstFunc(f func(MatchMeta) []byte) Option {
+	return func(o *options) {
+		o.substFunc = f
+	}
+}
+
+// WithSidecar causes the redactor to write a SidecarRecord, as a line of JSON,
+// to w for each substitution it writes. The records contain offsets and
+// fingerprints, never secrets, so a tool with access to the secrets can use
+// them to reconstruct the original output, while the output itself stays
+// redacted. A run of repeated substitutions collapsed by WithCollapseRepeats
+// has a single record, covering the whole run (including the whitespace
+// between them), with the fingerprint of the first secret in the run.
+//
+// Records are written as each substitution is written to the destination.
+// If writing a record fails, the error is returned (as for the destination),
+// and the record is not written again.
+func WithSidecar(w io.Writer) Option {
+	return func(o *options) {
+		o.sidecar = w
+	}
+}
+
+// WithHash causes the redactor to add the bytes it writes to the destination
+// to h, in order, as they are written, so that a checksum of the redacted
+// output can be computed without reading it again. Only the bytes the
+// destination accepts are added (those it doesn't are added when they are
+// written later, see Write). After SetDestination, h continues with the
+// output written to the new destination.
+func WithHash(h hash.Hash) Option {
+	return func(o *options) {
+		o.hash = h
+	}
+}
+
+// WithLogger sets a logger for warnings about the redactor's configuration,
+// such as a substitution that contains one of the needles (see Validate).
+func WithLogger(logger shell.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithName names the redactor, for identifying it in errors (see
+// MuxFlushError), for example by the name of its destination. The name must
+// not contain secrets.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
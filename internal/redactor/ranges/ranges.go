@@ -0,0 +1,88 @@
+// Package ranges implements a small algebra of contiguous integer ranges, such
+// as byte ranges within a buffer.
+package ranges
+
+import "sort"
+
+// Range designates a contiguous range of integers, such as slice indexes. It is
+// inclusive of From and exclusive of To.
+type Range struct {
+	From, To int
+}
+
+// Len returns the number of integers in the range.
+func (r Range) Len() int {
+	return r.To - r.From
+}
+
+// Sub returns the range shifted down by x.
+func (r Range) Sub(x int) Range {
+	r.From -= x
+	r.To -= x
+	return r
+}
+
+// Contains reports if the range contains x.
+func (r Range) Contains(x int) bool {
+	return r.From <= x && x < r.To
+}
+
+// Overlap reports if the two ranges overlap in any way.
+func (r Range) Overlap(s Range) bool {
+	return r.Contains(s.From) || s.Contains(r.From)
+}
+
+// Adjacent reports if one range begins exactly where the other ends.
+func (r Range) Adjacent(s Range) bool {
+	return r.To == s.From || s.To == r.From
+}
+
+// Union returns the smallest range containing both r and s.
+func (r Range) Union(s Range) Range {
+	if s.From < r.From {
+		r.From = s.From
+	}
+	if s.To > r.To {
+		r.To = s.To
+	}
+	return r
+}
+
+// Merge combines overlapping ranges. The input may be in any order. It sorts
+// and alters the contents of the input in place, and returns a prefix of it
+// containing the merged ranges sorted by From.
+func Merge(rs []Range) []Range {
+	return merge(rs, false)
+}
+
+// MergeAdjacent is like Merge, but also combines ranges that are adjacent.
+func MergeAdjacent(rs []Range) []Range {
+	return merge(rs, true)
+}
+
+func merge(rs []Range, adjacent bool) []Range {
+	// If there are none, or only one, then it's already merged.
+	if len(rs) <= 1 {
+		return rs
+	}
+
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].From != rs[j].From {
+			return rs[i].From < rs[j].From
+		}
+		return rs[i].To < rs[j].To
+	})
+
+	// Walking forwards, consider merging each rs[i] into rs[j]. Because the
+	// ranges are sorted by From, rs[i] can only overlap the latest rs[j].
+	j := 0
+	for i := 1; i < len(rs); i++ {
+		if rs[j].Overlap(rs[i]) || (adjacent && rs[j].Adjacent(rs[i])) {
+			rs[j] = rs[j].Union(rs[i])
+		} else {
+			j++
+			rs[j] = rs[i]
+		}
+	}
+	return rs[:j+1]
+}
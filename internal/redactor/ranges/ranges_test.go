@@ -0,0 +1,140 @@
+package ranges
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRangeAlgebra(t *testing.T) {
+	t.Parallel()
+
+	a := Range{From: 2, To: 5}
+
+	if got, want := a.Len(), 3; got != want {
+		t.Errorf("%v.Len() = %d, want %d", a, got, want)
+	}
+	if got, want := a.Sub(2), (Range{From: 0, To: 3}); got != want {
+		t.Errorf("%v.Sub(2) = %v, want %v", a, got, want)
+	}
+
+	for _, test := range []struct {
+		x    int
+		want bool
+	}{
+		{1, false},
+		{2, true},
+		{4, true},
+		{5, false},
+	} {
+		if got := a.Contains(test.x); got != test.want {
+			t.Errorf("%v.Contains(%d) = %t, want %t", a, test.x, got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		b                 Range
+		overlap, adjacent bool
+		union             Range
+	}{
+		{Range{From: 0, To: 1}, false, false, Range{From: 0, To: 5}},
+		{Range{From: 0, To: 2}, false, true, Range{From: 0, To: 5}},
+		{Range{From: 0, To: 3}, true, false, Range{From: 0, To: 5}},
+		{Range{From: 3, To: 4}, true, false, Range{From: 2, To: 5}},
+		{Range{From: 1, To: 7}, true, false, Range{From: 1, To: 7}},
+		{Range{From: 5, To: 7}, false, true, Range{From: 2, To: 7}},
+		{Range{From: 6, To: 7}, false, false, Range{From: 2, To: 7}},
+	} {
+		if got := a.Overlap(test.b); got != test.overlap {
+			t.Errorf("%v.Overlap(%v) = %t, want %t", a, test.b, got, test.overlap)
+		}
+		if got := test.b.Overlap(a); got != test.overlap {
+			t.Errorf("%v.Overlap(%v) = %t, want %t", test.b, a, got, test.overlap)
+		}
+		if got := a.Adjacent(test.b); got != test.adjacent {
+			t.Errorf("%v.Adjacent(%v) = %t, want %t", a, test.b, got, test.adjacent)
+		}
+		if got := a.Union(test.b); got != test.union {
+			t.Errorf("%v.Union(%v) = %v, want %v", a, test.b, got, test.union)
+		}
+		if got := test.b.Union(a); got != test.union {
+			t.Errorf("%v.Union(%v) = %v, want %v", test.b, a, got, test.union)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		input              []Range
+		want, wantAdjacent []Range
+	}{
+		{
+			name:         "empty",
+			input:        nil,
+			want:         nil,
+			wantAdjacent: nil,
+		},
+		{
+			name:         "single",
+			input:        []Range{{From: 1, To: 3}},
+			want:         []Range{{From: 1, To: 3}},
+			wantAdjacent: []Range{{From: 1, To: 3}},
+		},
+		{
+			name:         "disjoint",
+			input:        []Range{{From: 1, To: 3}, {From: 5, To: 8}},
+			want:         []Range{{From: 1, To: 3}, {From: 5, To: 8}},
+			wantAdjacent: []Range{{From: 1, To: 3}, {From: 5, To: 8}},
+		},
+		{
+			name:         "adjacent",
+			input:        []Range{{From: 1, To: 3}, {From: 3, To: 8}, {From: 8, To: 9}},
+			want:         []Range{{From: 1, To: 3}, {From: 3, To: 8}, {From: 8, To: 9}},
+			wantAdjacent: []Range{{From: 1, To: 9}},
+		},
+		{
+			name:         "overlapping",
+			input:        []Range{{From: 1, To: 4}, {From: 3, To: 8}, {From: 10, To: 12}},
+			want:         []Range{{From: 1, To: 8}, {From: 10, To: 12}},
+			wantAdjacent: []Range{{From: 1, To: 8}, {From: 10, To: 12}},
+		},
+		{
+			name:         "nested",
+			input:        []Range{{From: 1, To: 10}, {From: 2, To: 4}, {From: 5, To: 7}},
+			want:         []Range{{From: 1, To: 10}},
+			wantAdjacent: []Range{{From: 1, To: 10}},
+		},
+		{
+			name:         "reverse ordered",
+			input:        []Range{{From: 12, To: 14}, {From: 6, To: 9}, {From: 4, To: 7}, {From: 0, To: 2}},
+			want:         []Range{{From: 0, To: 2}, {From: 4, To: 9}, {From: 12, To: 14}},
+			wantAdjacent: []Range{{From: 0, To: 2}, {From: 4, To: 9}, {From: 12, To: 14}},
+		},
+		{
+			name:         "sorted by to",
+			input:        []Range{{From: 5, To: 6}, {From: 0, To: 7}, {From: 7, To: 9}},
+			want:         []Range{{From: 0, To: 7}, {From: 7, To: 9}},
+			wantAdjacent: []Range{{From: 0, To: 9}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := Merge(append([]Range(nil), test.input...))
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("Merge(%v) diff (-got +want):\n%s", test.input, diff)
+			}
+
+			got = MergeAdjacent(append([]Range(nil), test.input...))
+			if diff := cmp.Diff(got, test.wantAdjacent); diff != "" {
+				t.Errorf("MergeAdjacent(%v) diff (-got +want):\n%s", test.input, diff)
+			}
+		})
+	}
+}
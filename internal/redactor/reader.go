@@ -0,0 +1,81 @@
+package redactor
+
+import (
+	"bytes"
+	"io"
+)
+
+// Reader redacts secrets from the data read from an underlying reader.
+//
+// Like Redactor, a Reader holds back bytes that could be the start of a
+// secret until it knows whether they are. When the underlying reader returns
+// io.EOF, any held-back bytes are treated as non-matches and returned before
+// the Reader itself returns io.EOF, so the tail of the stream is never lost.
+// A secret that ends exactly at the end of the stream is redacted.
+//
+// If the underlying reader returns any other error, bytes already known to be
+// safe are returned before the error, but held-back bytes are not (they may be
+// the start of a secret that would have been completed).
+type Reader struct {
+	src      io.Reader
+	redactor *Redactor
+
+	// Redacted output not yet returned by Read.
+	out bytes.Buffer
+
+	// Buffer for reading from src.
+	in []byte
+
+	// Error returned by src, returned once out is drained.
+	err error
+}
+
+// NewReader returns a Reader that reads from src, redacting the needles.
+func NewReader(src io.Reader, subst string, needles []string, opts ...Option) *Reader {
+	rd := newReader(src)
+	rd.redactor = New(&rd.out, subst, needles, opts...)
+	return rd
+}
+
+// NewReaderWithNeedleSet is like NewReader, but redacts the needles in a
+// pre-built NeedleSet (see NewWithNeedleSet).
+func NewReaderWithNeedleSet(src io.Reader, subst string, needles *NeedleSet, opts ...Option) *Reader {
+	rd := newReader(src)
+	rd.redactor = NewWithNeedleSet(&rd.out, subst, needles, opts...)
+	return rd
+}
+
+// newReader returns a Reader reading from src, without a redactor.
+func newReader(src io.Reader) *Reader {
+	return &Reader{
+		src: src,
+		in:  make([]byte, 32*1024),
+	}
+}
+
+// Read reads redacted data into p.
+func (rd *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for rd.out.Len() == 0 && rd.err == nil {
+		n, err := rd.src.Read(rd.in)
+		if n > 0 {
+			// Writes to a bytes.Buffer don't fail.
+			_, _ = rd.redactor.Write(rd.in[:n])
+		}
+		if err != nil {
+			rd.err = err
+			if err == io.EOF {
+				// No more data, so any held-back bytes are not secrets.
+				_ = rd.redactor.Flush()
+			}
+		}
+	}
+
+	if rd.out.Len() > 0 {
+		return rd.out.Read(p)
+	}
+	return 0, rd.err
+}
@@ -0,0 +1,90 @@
+package redactor
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc    string
+		input   string
+		needles []string
+		want    string
+	}{
+		{
+			desc:    "No secrets",
+			input:   lipsum,
+			needles: nil,
+			want:    lipsum,
+		},
+		{
+			desc:    "Secret in the middle",
+			input:   lipsum,
+			needles: []string{"dolor"},
+			want:    "Lorem ipsum [REDACTED] sit amet",
+		},
+		{
+			desc:    "EOF at secret boundary",
+			input:   lipsum,
+			needles: []string{"amet"},
+			want:    "Lorem ipsum dolor sit [REDACTED]",
+		},
+		{
+			desc:    "EOF mid partial match",
+			input:   lipsum,
+			needles: []string{"amet, consectetur"},
+			want:    lipsum,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		for name, wrap := range map[string]func(io.Reader) io.Reader{
+			"One read;":   func(r io.Reader) io.Reader { return r },
+			"Many reads;": iotest.OneByteReader,
+			"Data EOF;":   iotest.DataErrReader,
+		} {
+			wrap := wrap
+			t.Run(name+test.desc, func(t *testing.T) {
+				t.Parallel()
+
+				rd := NewReader(wrap(strings.NewReader(test.input)), "[REDACTED]", test.needles)
+				got, err := io.ReadAll(iotest.OneByteReader(rd))
+				if err != nil {
+					t.Fatalf("io.ReadAll(rd) error = %v", err)
+				}
+				if got, want := string(got), test.want; got != want {
+					t.Errorf("io.ReadAll(rd) = %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestReaderSourceError(t *testing.T) {
+	t.Parallel()
+
+	errBroken := errors.New("broken pipe")
+	src := io.MultiReader(
+		strings.NewReader("Lorem ipsum dolor sit am"),
+		iotest.ErrReader(errBroken),
+	)
+
+	rd := NewReader(src, "[REDACTED]", []string{"ipsum", "amet"})
+	got, err := io.ReadAll(rd)
+	if !errors.Is(err, errBroken) {
+		t.Errorf("io.ReadAll(rd) error = %v, want %v", err, errBroken)
+	}
+
+	// The safe bytes are delivered, but not "am", which could have been the
+	// start of a secret.
+	if got, want := string(got), "Lorem [REDACTED] dolor sit "; got != want {
+		t.Errorf("io.ReadAll(rd) = %q, want %q", got, want)
+	}
+}
@@ -2,21 +2,47 @@
 package redactor
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"path"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/buildkite/agent/v3/internal/redactor/ranges"
 )
 
+// writeChunkSize is the largest amount of input processed by Write before
+// flushing. It matches the initial buffer capacity.
+const writeChunkSize = 65536
+
 // RedactLengthMin is the shortest string length that will be considered a
 // potential secret by the environment redactor. e.g. if the redactor is
 // configured to filter out environment variables matching *_TOKEN, and
 // API_TOKEN is set to "none", this minimum length will prevent the word "none"
-// from being redacted from useful log output.
+// from being redacted from useful log output. It is measured in bytes, unless
+// another unit is given to VarsToRedactWithLengthUnit.
+//
+// The minimum is a policy of the functions that choose secrets (VarsToRedact,
+// NeedlesFromFiles), and of options that derive or learn needles. New, Reset,
+// and the other functions that take needles directly don't enforce it: any
+// non-empty needle given to them is redacted, however short.
 const RedactLengthMin = 6
 
+// ErrStalled is returned (wrapped) by Write when the redactor was created
+// with WithStallTimeout, and buffered data has not been written for longer
+// than the timeout.
+var ErrStalled = errors.New("redactor output stalled")
+
 // Redactor is a straightforward secret redactor.
 //
 // The algorithm is intended to be easier to maintain than certain
@@ -27,18 +53,35 @@ type Redactor struct {
 	// Replacement string (e.g. "[REDACTED]")
 	subst []byte
 
-	// Secrets to redact (looking for these needles in the haystack),
-	// organised by first byte.
-	// Why first byte? Because looking up needles by the first byte is a lot
-	// faster than _filtering_ all the needles by first byte.
-	needlesByFirstByte [256][]string
+	// Secrets to redact (looking for these needles in the haystack).
+	// The set is immutable, and may be shared with other redactors.
+	needles *NeedleSet
 
-	// For synchronising writes. Each write can touch everything below.
+	// For synchronising writes. Each write can touch everything below, except
+	// fed.
 	mu sync.Mutex
 
+	// Needles passed to AddNeedleAsync, to be added at the start of the next
+	// Write. Guarded by feedMu rather than mu.
+	feedMu sync.Mutex
+	fed    []string
+
 	// Redacted output written to this writer.
 	dst io.Writer
 
+	// If not nil, output is written through this before crc (or dst).
+	ld *lineDropper
+
+	// If not nil, output is written through this before dst.
+	crc *crCollapser
+
+	// If not nil, output is buffered in this before dst (but after crc).
+	bw *bufio.Writer
+
+	// If not nil, output is written through this (after bw) to dst, to be
+	// hashed.
+	hw *hashWriter
+
 	// Intermediate buffer to account for partially-written non-secrets.
 	// (i.e. we began redacting in case we're in the middle of a secret, but
 	// we might not be).
@@ -53,26 +96,367 @@ type Redactor struct {
 
 	// The ranges in buf we must redact on flush.
 	completedMatches []subrange
+
+	// Ranges added to completedMatches since they were last merged, for
+	// WithOnMatches. Only kept if it is set.
+	newMatches []subrange
+
+	// Matches of needles with a context window after them, which are still
+	// being widened.
+	windows []contextWindow
+
+	// Needles learned with WithLearning, oldest first.
+	learned []string
+
+	// Region start markers added by AddRegion, which are kept across Reset.
+	regions []Needle
+
+	// The result of checking the substitution against the needles, whenever
+	// they change (see Validate).
+	substErr error
+
+	// Position of buf[0] within the input stream.
+	offset int
+
+	// The byte in the input stream before buf[0] (if offset > 0).
+	prevByte byte
+
+	// The number of bytes since the last line feed in the input stream, and
+	// whether the last byte was a line feed wrapping a line at that column.
+	// Only maintained if opts.unwrapColumn is set.
+	column  int
+	wrapped bool
+
+	// Number of redactions written so far, and their total length.
+	redactions    int
+	redactedBytes int
+
+	// Number of matches of each needle so far, by fingerprint.
+	matchesByFingerprint map[string]int
+
+	// Number of bytes of output produced so far, including any in unwritten.
+	written int
+
+	// Output that could not be written because of an error from the
+	// destination. It is written before any other output.
+	unwritten []byte
+
+	// Output to write as is, in place within the buffer (see insert).
+	inserts []insertion
+
+	// Number of calls to Write so far.
+	writes int
+
+	// Stops the timer started by the last Write for WithIdleFlush, if any.
+	stopIdleFlush func() bool
+
+	// Whether partial matches were carried over from the end of the last
+	// frame (see endFrame), and so the buffer isn't flushed before the next.
+	carrying bool
+
+	// The largest len(buf) has been.
+	peakBuffered int
+
+	// The number of bytes processed when ResetStats was last called.
+	processedBase int
+
+	// Expiry times of needles added by AddNeedleWithTTL.
+	expiries map[string]time.Time
+
+	// Values of needles redacted since the last Reset. Only maintained if
+	// opts.firstOccurrenceOnly is set.
+	redactedOnce map[string]bool
+
+	// When bytes were last written out of buf, or data was last buffered
+	// into an empty buf. Only maintained if opts.stallTimeout is set.
+	lastFlush time.Time
+
+	// Optional behaviour.
+	opts options
+}
+
+// Redaction describes a single redacted range of the input stream.
+type Redaction struct {
+	// Offset is the position of the start of the range in the input stream.
+	Offset int
+
+	// Length is the length of the range in the input stream.
+	Length int
+
+	// Fingerprint is the fingerprint (see Fingerprint) of the secret that
+	// matched. If the range was formed from overlapping matches of several
+	// secrets, it is the fingerprint of the one whose replacement was used.
+	Fingerprint string
+}
+
+// MatchMeta describes a redacted range to a substitution function (see
+// WithSubstFunc). It never contains the secret itself.
+type MatchMeta struct {
+	// Length is the length of the range in the input stream.
+	Length int
+
+	// Fingerprint is the fingerprint (see Fingerprint) of the secret that
+	// matched, as in Redaction.
+	Fingerprint string
+
+	// LineBreaks are the offsets within the range of any line feeds in it,
+	// such as in a multi-line secret like a PEM block. A substitution that
+	// keeps them keeps the line count of the output the same as the input's.
+	LineBreaks []int
+}
+
+// PreserveLengthMask returns a substitution function for WithSubstFunc that
+// replaces each redacted range with the same number of mask bytes, except for
+// line feeds, which are kept where they were. So the redacted output has the
+// same length, and the same lines, as the input, which keeps line numbers and
+// column positions meaningful to downstream tools.
+//
+// The exception is overlapping secrets split across Writes: a substitution is
+// never split, so once the mask for one secret has been written, the part of
+// an overlapping secret that extends past it is redacted without any mask.
+func PreserveLengthMask(mask byte) func(MatchMeta) []byte {
+	return func(m MatchMeta) []byte {
+		out := bytes.Repeat([]byte{mask}, m.Length)
+		for _, i := range m.LineBreaks {
+			out[i] = '\n'
+		}
+		return out
+	}
+}
+
+// OffsetMapping relates a redacted range of the input stream to the
+// substitution written in its place in the output stream. Outside of redacted
+// ranges, input and output bytes correspond one-to-one, so a sequence of
+// OffsetMappings is enough to translate offsets between the two streams.
+type OffsetMapping struct {
+	// InputOffset and InputLength describe the redacted range in the input.
+	InputOffset, InputLength int
+
+	// OutputOffset and OutputLength describe the substitution in the output.
+	OutputOffset, OutputLength int
+}
+
+// SidecarRecord is written (as a line of JSON) to the sidecar writer set by
+// WithSidecar for each substitution. With the secrets, the records are enough
+// to reconstruct the input from the output.
+type SidecarRecord struct {
+	// InputOffset and InputLength describe the redacted range in the input.
+	InputOffset int `json:"input_offset"`
+	InputLength int `json:"input_length"`
+
+	// OutputOffset and OutputLength describe the substitution in the output.
+	OutputOffset int `json:"output_offset"`
+	OutputLength int `json:"output_length"`
+
+	// Fingerprint is the fingerprint of the secret that matched, as in
+	// Redaction.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Stats contains statistics about a Redactor.
+type Stats struct {
+	// Redactions is the number of redacted ranges written so far.
+	Redactions int
+
+	// PeakBuffered is the largest number of bytes the redactor has held in
+	// its buffer at once.
+	PeakBuffered int
+
+	// Buffered is the number of bytes currently held in the buffer, waiting
+	// to be redacted or written.
+	Buffered int
+
+	// Processed is the total number of bytes passed to Write so far.
+	Processed int
+}
+
+// Manifest summarizes the redactions a redactor has made, for auditing, such
+// as at the end of a job (see FlushWithManifest). It identifies secrets by
+// fingerprint, and never contains them. Like Stats, it covers the life of the
+// redactor, or the time since ResetStats was last called.
+type Manifest struct {
+	Stats
+
+	// Matches is the number of matches of each needle, by fingerprint (see
+	// Fingerprint). Matches that overlap (or are merged, see
+	// WithAdjacentMerge) are each counted, but are redacted together, so the
+	// total can be more than Redactions.
+	Matches map[string]int
+
+	// RedactedBytes is the total length of the input redacted.
+	RedactedBytes int
+}
+
+// New returns a new Redactor. Every non-empty needle is redacted, even one
+// shorter than RedactLengthMin.
+func New(dst io.Writer, subst string, needles []string, opts ...Option) *Redactor {
+	r := newRedactor(dst, subst, opts)
+	r.setNeedles(r.opts.needleSet(NeedleSlice(needles)))
+	return r
+}
+
+// NewFromBytes is like New, but the needles are given as byte slices. The
+// redactor copies the needles, so the caller may zero its slices (e.g.
+// `for i := range b { b[i] = 0 }`) as soon as NewFromBytes returns, without
+// affecting redaction.
+//
+// This limits how long the caller's copy of each secret lives, but it is not
+// a guarantee that secrets leave memory: the redactor keeps its own copies
+// (and any expanded forms, e.g. escaped variants) for as long as it uses them,
+// and these can't be zeroed.
+func NewFromBytes(dst io.Writer, subst string, needles [][]byte, opts ...Option) *Redactor {
+	return New(dst, subst, copyNeedles(needles), opts...)
+}
+
+// copyNeedles copies byte slice needles into strings, which don't alias the
+// caller's slices.
+func copyNeedles(needles [][]byte) []string {
+	if needles == nil {
+		return nil
+	}
+	vals := make([]string, len(needles))
+	for i, b := range needles {
+		vals[i] = string(b)
+	}
+	return vals
+}
+
+// RedactAll returns a copy of input with the needles redacted. It is
+// equivalent to writing all of input to a new Redactor and flushing it.
+func RedactAll(input []byte, subst string, needles []string) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(input))
+	r := New(&buf, subst, needles)
+	// Writing to a bytes.Buffer can't fail.
+	r.Write(input)
+	r.Flush()
+	return buf.Bytes()
+}
+
+// RedactAllContext is like RedactAll, but checks ctx between chunks of the
+// input, so that redacting a large input can be cancelled. If ctx is done
+// before all of input is redacted, it returns the redacted output so far,
+// along with ctx.Err(). The output so far is a prefix of the output of
+// RedactAll: it never ends partway through a substitution, and input that
+// could be the start of a secret is left out rather than written unredacted.
+func RedactAllContext(ctx context.Context, input []byte, subst string, needles []string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(input))
+	r := New(&buf, subst, needles)
+	for len(input) > 0 {
+		if err := ctx.Err(); err != nil {
+			return buf.Bytes(), err
+		}
+		chunk := input
+		if len(chunk) > writeChunkSize {
+			chunk = chunk[:writeChunkSize]
+		}
+		// Writing to a bytes.Buffer can't fail.
+		r.Write(chunk)
+		input = input[len(chunk):]
+	}
+	r.Flush()
+	return buf.Bytes(), nil
+}
+
+// RedactAllString is like RedactAll, but for strings.
+func RedactAllString(input, subst string, needles []string) string {
+	return string(RedactAll([]byte(input), subst, needles))
+}
+
+// Redactf formats according to format, like fmt.Sprintf, and returns the
+// result with the needles redacted, whether they came from args or from format
+// itself. A secret is only redacted where it appears verbatim in the result,
+// so verbs that transform an argument (such as %q or %x) can leave it
+// unredacted.
+func Redactf(needles []string, subst, format string, args ...any) string {
+	return RedactAllString(fmt.Sprintf(format, args...), subst, needles)
+}
+
+// NewWithNeedleSet returns a new Redactor that redacts the needles in a
+// pre-built NeedleSet. Because NeedleSets are immutable, the same set can be
+// passed to many redactors, avoiding the cost of bucketing the needles for
+// each one.
+//
+// Options that derive extra needles from each secret (such as
+// WithUnicodeNormalization) are not applied to the NeedleSet, only to needles
+// passed to Reset.
+func NewWithNeedleSet(dst io.Writer, subst string, needles *NeedleSet, opts ...Option) *Redactor {
+	r := newRedactor(dst, subst, opts)
+	r.setNeedles(needles)
+	return r
 }
 
-// New returns a new Redactor.
-func New(dst io.Writer, subst string, needles []string) *Redactor {
+// newRedactor returns a new Redactor without any needles.
+func newRedactor(dst io.Writer, subst string, opts []Option) *Redactor {
 	r := &Redactor{
 		dst:   dst,
 		subst: []byte(subst),
 
-		// Preallocate a few things.
-		buf:              make([]byte, 0, 65536),
-		partialMatches:   make([]partialMatch, 0, len(needles)),
-		nextMatches:      make([]partialMatch, 0, len(needles)),
-		completedMatches: make([]subrange, 0, len(needles)),
+		// Preallocate the buffer.
+		buf: make([]byte, 0, writeChunkSize),
+	}
+	for _, o := range opts {
+		o(&r.opts)
+	}
+	r.setupOutput()
+	if r.opts.now == nil {
+		r.opts.now = time.Now
+	}
+	if r.opts.afterFunc == nil {
+		r.opts.afterFunc = afterFunc
 	}
-	r.Reset(needles)
 	return r
 }
 
+// afterFunc calls f after d, like time.AfterFunc, and returns a function to
+// stop the timer.
+func afterFunc(d time.Duration, f func()) (stop func() bool) {
+	return time.AfterFunc(d, f).Stop
+}
+
+// setupOutput creates the writers between the redactor and r.dst required by
+// the options.
+func (r *Redactor) setupOutput() {
+	out := r.dst
+	r.hw = nil
+	if r.opts.hash != nil {
+		r.hw = &hashWriter{dst: r.dst, h: r.opts.hash}
+		out = r.hw
+	}
+	r.bw = nil
+	if r.opts.bufferSize > 0 {
+		r.bw = bufio.NewWriterSize(out, r.opts.bufferSize)
+		out = r.bw
+	}
+	r.crc = nil
+	if r.opts.collapseCR {
+		r.crc = &crCollapser{lineOutput: lineOutput{dst: out}}
+		out = r.crc
+	}
+	r.ld = nil
+	if r.opts.policy == DropLine {
+		r.ld = &lineDropper{lineOutput: lineOutput{dst: out}}
+	}
+}
+
 // Write redacts any secrets from the stream, and forwards the redacted stream
 // to the destination writer.
+//
+// Write is safe to call from multiple goroutines: concurrent calls are
+// serialized, and the input of each is processed contiguously. But the order
+// of concurrent calls is arbitrary, and by default a secret can be matched
+// across consecutive calls, since a stream writer may split a secret between
+// Writes. To treat each call as a separate message for matching purposes,
+// use WriteLine, or WithFramedWrites.
+//
+// If the destination returns an error, Write returns it, but the input is
+// still consumed: the output the destination didn't accept is kept, and
+// written before any other output by the next Write, Sync, or Flush. So after
+// an error, don't write the same input again (that would duplicate it); once
+// the destination recovers, continue with the next input, or call Flush.
+// Output buffered by WithBufferedOutput can't be retried this way, since a
+// bufio.Writer stops writing after its first error.
 func (r *Redactor) Write(b []byte) (int, error) {
 	if len(b) == 0 {
 		return 0, nil
@@ -81,6 +465,118 @@ func (r *Redactor) Write(b []byte) (int, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	defer r.armIdleFlush()
+
+	if r.opts.framedWrites {
+		return r.writeFrame(b)
+	}
+	n, err := r.writeChunks(b)
+	if err == nil {
+		err = r.flushOutput()
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, r.checkStall()
+}
+
+// WriteLine redacts any secrets from b and forwards it to the destination
+// writer, treating b as a complete message: secrets are matched within b, but
+// not across it and the input of any other call. Any input buffered from an
+// earlier Write is flushed first, and nothing from b remains buffered
+// afterwards. This is useful when many goroutines share a redactor, so that
+// bytes from one goroutine's message can't be joined with another's to form
+// (or break up) a secret.
+//
+// The exception is a secret spanning lines, written a line at a time: if b
+// ends with a line feed that could be part of a secret (a needle containing a
+// line feed, or one skipping it, see Needle.Delimiters and WithUnwrapColumn),
+// the candidate is held, and matching continues in the next call, so as not
+// to leak the rest of the secret.
+func (r *Redactor) WriteLine(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.writeFrame(b)
+}
+
+// writeFrame does the work of WriteLine.
+func (r *Redactor) writeFrame(b []byte) (int, error) {
+	if !r.carrying {
+		if err := r.flush(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.writeChunks(b)
+	if err != nil {
+		return n, err
+	}
+	return n, r.endFrame()
+}
+
+// endFrame flushes the buffer at the end of a frame. If the frame ends with a
+// line feed, partial matches that include it (of needles containing a line
+// feed, or skipping one) are carried over to the next frame, rather than
+// abandoned: otherwise a secret spanning lines would never be matched when
+// written a line at a time.
+func (r *Redactor) endFrame() error {
+	if len(r.buf) == 0 || r.buf[len(r.buf)-1] != '\n' {
+		return r.flush()
+	}
+	// Any incomplete partial match still alive has matched (or skipped) the
+	// final line feed.
+	var carry []partialMatch
+	for _, s := range r.partialMatches {
+		if s.matched < len(s.needle.value) {
+			carry = append(carry, s)
+		}
+	}
+	return r.flushKeeping(carry)
+}
+
+// writeChunks does the work of Write.
+func (r *Redactor) writeChunks(b []byte) (int, error) {
+	r.writes++
+	r.expireNeedles()
+	r.addFedNeedles()
+	if r.opts.stallTimeout > 0 && len(r.buf) == 0 {
+		r.lastFlush = r.opts.now()
+	}
+
+	// Process large inputs in chunks, flushing after each, so that the buffer
+	// doesn't grow to the size of the input. Because partial matches carry
+	// over between chunks exactly as they do between Writes, the result is
+	// the same as if the caller had written each chunk separately.
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > writeChunkSize {
+			chunk = chunk[:writeChunkSize]
+		}
+		n, err := r.writeChunk(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// checkStall returns an error if buffered data has not been written for
+// longer than the stall timeout.
+func (r *Redactor) checkStall() error {
+	if r.opts.stallTimeout <= 0 || len(r.buf) == 0 {
+		return nil
+	}
+	if stalled := r.opts.now().Sub(r.lastFlush); stalled > r.opts.stallTimeout {
+		return fmt.Errorf("%w: %d bytes buffered without being written for %v", ErrStalled, len(r.buf), stalled)
+	}
+	return nil
+}
+
+// writeChunk does the work of Write for each chunk of the input.
+func (r *Redactor) writeChunk(b []byte) (int, error) {
 	// The high level:
 	// 1. Append b to the buffer.
 	// 2. Search through b to find instances of strings to redact. Store the
@@ -102,16 +598,46 @@ func (r *Redactor) Write(b []byte) (int, error) {
 
 	// 1. Append b to the buffer.
 	r.buf = append(r.buf, b...)
+	if len(r.buf) > r.peakBuffered {
+		r.peakBuffered = len(r.buf)
+	}
 
 	// 2. Search through b to find instances of strings to redact. Store the
 	//    ranges of redactions in r.redact.
 	for n, c := range b {
 		bufidx := n + prevBufLen // where we are in the whole buffer
 
+		// Ranges completed on this byte end at bufidx+1. Word-bounded ranges
+		// resolved on this byte end at bufidx, so they are inserted before
+		// these to keep r.completedMatches sorted.
+		completedBefore := len(r.completedMatches)
+
+		// Is this a line feed inserted by hard-wrapping?
+		wrap := r.opts.unwrapColumn > 0 && c == '\n' && r.column == r.opts.unwrapColumn
+
 		// In the middle of matching?
 		for _, s := range r.partialMatches {
+			if s.matched == len(s.needle.value) {
+				// A word-bounded needle that matched entirely, waiting to see
+				// if the next byte is a word boundary.
+				if !isWordByte(c) {
+					r.completeRange(completedBefore, subrange{
+						from:   bufidx - s.span(),
+						to:     bufidx,
+						needle: s.needle,
+					})
+				}
+				continue
+			}
+
 			// Does the needle match on this byte?
-			if c != s.needle[s.matched] {
+			if c != s.needle.value[s.matched] {
+				if s.needle.ignores(c) || wrap {
+					// A delimiter (or wrapping) within the match; skip over it.
+					s.skipped++
+					r.nextMatches = append(r.nextMatches, s)
+					continue
+				}
 				// No - drop this partial match.
 				continue
 			}
@@ -120,34 +646,78 @@ func (r *Redactor) Write(b []byte) (int, error) {
 			s.matched++
 
 			// Have we fully matched this needle?
-			if s.matched < len(s.needle) {
+			if s.matched < len(s.needle.value) {
 				// This state survives for another byte.
 				r.nextMatches = append(r.nextMatches, s)
 				continue
 			}
 
 			// Match complete; save range to redact.
-			r.completedMatches = append(r.completedMatches, subrange{
-				from: bufidx - len(s.needle) + 1,
-				to:   bufidx + 1,
-			})
+			r.complete(s, bufidx)
 		}
 
 		// Start matching something?
-		for _, s := range r.needlesByFirstByte[c] {
-			if len(s) == 1 {
-				// A pathological case; in practice we don't redact secrets
-				// smaller than RedactLengthMin.
-				r.completedMatches = append(r.completedMatches, subrange{
-					from: bufidx,
-					to:   bufidx + 1,
-				})
-				continue
-			}
-			r.nextMatches = append(r.nextMatches, partialMatch{
+		for _, s := range r.needles.byFirstByte[c] {
+			pm := partialMatch{
 				needle:  s,
 				matched: 1,
-			})
+				since:   r.writes,
+			}
+			if len(s.value) == 1 {
+				// A needle this short completes on its first byte. Needles
+				// chosen by VarsToRedact are never this short, but callers
+				// may pass them directly (see RedactLengthMin).
+				r.complete(pm, bufidx)
+				continue
+			}
+			r.nextMatches = append(r.nextMatches, pm)
+		}
+		if r.needles.byFirstTwoBytes != nil && bufidx > 0 {
+			key := firstTwoBytes(r.buf[bufidx-1], c)
+			for _, s := range r.needles.byFirstTwoBytes[key] {
+				// This needle started on the previous byte.
+				pm := partialMatch{
+					needle:  s,
+					matched: 2,
+					since:   r.writes,
+				}
+				if len(s.value) == 2 {
+					r.complete(pm, bufidx)
+					continue
+				}
+				r.nextMatches = append(r.nextMatches, pm)
+			}
+			if r.wrapped && bufidx > 1 {
+				// The needle may have started before the wrapping line feed
+				// on the previous byte.
+				key := firstTwoBytes(r.buf[bufidx-2], c)
+				for _, s := range r.needles.byFirstTwoBytes[key] {
+					pm := partialMatch{
+						needle:  s,
+						matched: 2,
+						skipped: 1,
+						since:   r.writes,
+					}
+					if len(s.value) == 2 {
+						r.complete(pm, bufidx)
+						continue
+					}
+					r.nextMatches = append(r.nextMatches, pm)
+				}
+			}
+		}
+
+		if len(r.windows) > 0 {
+			r.widenWindows(c, bufidx, completedBefore)
+		}
+
+		if r.opts.unwrapColumn > 0 {
+			r.wrapped = wrap
+			if c == '\n' {
+				r.column = 0
+			} else {
+				r.column++
+			}
 		}
 
 		// r.nextMatches now contains the new set of partial matches.
@@ -158,23 +728,108 @@ func (r *Redactor) Write(b []byte) (int, error) {
 
 	// 3. Merge overlapping redaction ranges.
 	// Because they were added from start to end, they are in order.
-	r.completedMatches = mergeOverlaps(r.completedMatches)
+	if debugInvariants {
+		checkSortedByTo(r.completedMatches, "writeChunk")
+	}
+	r.reportMatches()
+	r.completedMatches = mergeOverlaps(r.completedMatches, r.opts.mergeAdjacent)
+	r.completedMatches = mergeGaps(r.completedMatches, r.opts.mergeGap)
 
 	// 4. Write as much of the buffer as we can without spilling incomplete
 	//    matches.
+	limit := r.flushLimit()
+	if err := r.flushUpTo(limit); err != nil {
+		// All of b is in the buffer, and the output that couldn't be written
+		// is kept, so b has been consumed. Writing b again would duplicate it.
+		return len(b), err
+	}
+
+	// We "wrote" all of b, so report len(b).
+	return len(b), nil
+}
+
+// flushLimit returns how much of the buffer can be written without spilling
+// incomplete matches, or anything else that could be affected by future input.
+func (r *Redactor) flushLimit() int {
+	return r.limit(false)
+}
+
+// limit does the work of flushLimit. If idle is set, redactions held back only
+// in case they can be merged or collapsed with ones yet to be found are not
+// held back, since the input has paused (see WithIdleFlush).
+func (r *Redactor) limit(idle bool) int {
 	limit := len(r.buf)
 	for _, s := range r.partialMatches {
-		if to := len(r.buf) - s.matched; to < limit {
+		if to := len(r.buf) - s.span(); to < limit {
 			limit = to
 		}
 	}
-	if err := r.flushUpTo(limit); err != nil {
-		// We "wrote" this much of b in this Write at the point of error.
-		return limit - prevBufLen, err
+	for _, w := range r.windows {
+		from := w.from
+		if w.quote != 0 {
+			// The window includes the opening quote if it is closed.
+			from--
+		}
+		if from < limit {
+			limit = from
+		}
 	}
-
-	// We "wrote" all of b, so report len(b).
-	return len(b), nil
+	if r.needles.byFirstTwoBytes != nil && limit > 0 && limit == len(r.buf) {
+		// The last byte could be the first byte of a needle bucketed by its
+		// first two bytes, which is only checked on the following byte.
+		limit--
+		if r.wrapped && limit > 0 {
+			// Or the last byte is a wrapping line feed, and the byte before
+			// it could be.
+			limit--
+		}
+	}
+	if r.opts.surroundingQuotes && limit > 0 && isQuote(r.buf[limit-1]) {
+		// The quote could open a quoted secret that is yet to be found.
+		limit--
+	}
+	if (r.opts.mergeAdjacent || r.opts.mergeGap > 0) && !idle {
+		// A range ending at (or within the merge gap of) the limit could be
+		// merged with a range that is yet to be found, so hold it back until
+		// more input is seen.
+		for i := len(r.completedMatches) - 1; i >= 0; i-- {
+			match := r.completedMatches[i]
+			if match.to+r.opts.mergeGap < limit {
+				break
+			}
+			if match.from < limit {
+				limit = match.from
+			}
+		}
+	}
+	if r.opts.collapseRepeats > 0 && !idle {
+		// If the input so far ends with a run of repeated redactions (and
+		// whitespace), the run could continue. Hold it back until it ends.
+		last := len(r.completedMatches) - 1
+		for last >= 0 && r.completedMatches[last].from >= limit {
+			last--
+		}
+		if last >= 0 {
+			match := r.completedMatches[last]
+			if match.to >= limit || isAllSpace(r.buf[match.to:limit]) {
+				n := r.sameRun(last, -1, limit)
+				limit = r.completedMatches[last-n+1].from
+			}
+		}
+	}
+	before := r.needles.maxContextBefore
+	if r.opts.learnWindow > before {
+		before = r.opts.learnWindow
+	}
+	if before > 0 && limit > 0 {
+		// The end of the current line could be the window before a match
+		// that is yet to be found.
+		lineStart := bytes.LastIndexByte(r.buf[:limit], '\n') + 1
+		if limit -= before; limit < lineStart {
+			limit = lineStart
+		}
+	}
+	return limit
 }
 
 // Flush writes all buffered data to the destination. It assumes there is no
@@ -183,78 +838,329 @@ func (r *Redactor) Flush() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// Since there is no more incoming data, any remaining partial matches
-	// cannot complete.
-	r.partialMatches = r.partialMatches[:0]
-	return r.flushUpTo(len(r.buf))
+	return r.flush()
 }
 
-// flush writes out the buffer up to an index. limit is an upper limit.
-func (r *Redactor) flushUpTo(limit int) error {
-	if limit == 0 || len(r.buf) == 0 {
-		return nil
-	}
+// FlushWithManifest is like Flush, but also returns a Manifest of the
+// redactions made, including those made by the flush. The manifest is
+// returned even if the flush fails.
+func (r *Redactor) FlushWithManifest() (Manifest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	bufidx := 0 // where we are up to in the buffer
-	done := -1  // the index of the last match processed
+	err := r.flush()
+	m := Manifest{
+		Stats:         r.stats(),
+		Matches:       make(map[string]int, len(r.matchesByFingerprint)),
+		RedactedBytes: r.redactedBytes,
+	}
+	for fp, n := range r.matchesByFingerprint {
+		m.Matches[fp] = n
+	}
+	return m, err
+}
 
-	// Stop when we're out of redactions, or the next one is after limit.
+// flush does the work of Flush.
+func (r *Redactor) flush() error {
+	return r.flushKeeping(nil)
+}
 
-	for ri, match := range r.completedMatches {
-		if match.from >= limit {
-			// This range is after the cutoff point.
-			break
+// flushKeeping is like flush, except that the partial matches in keep
+// continue, and the part of the buffer they cover is kept.
+func (r *Redactor) flushKeeping(keep []partialMatch) error {
+	// Since there is no more incoming data, any remaining partial matches
+	// cannot complete. The exception is word-bounded needles that matched
+	// entirely: the end of the stream is a word boundary.
+	for _, s := range r.partialMatches {
+		if s.matched == len(s.needle.value) {
+			r.addCompleted(len(r.completedMatches), subrange{
+				from:   len(r.buf) - s.span(),
+				to:     len(r.buf),
+				needle: s.needle,
+			})
 		}
-		done = ri
-
-		switch {
-		case bufidx < match.from:
-			// A non-redacted range (followed by a redacted range).
-			if _, err := r.dst.Write(r.buf[bufidx:match.from]); err != nil {
-				return err
-			}
-			fallthrough
-
-		case bufidx == match.from:
-			// A redacted range.
-			// Write a r.subst instead of the redacted range.
-			if _, err := r.dst.Write(r.subst); err != nil {
-				return err
-			}
-			bufidx = match.to
-			// bufidx could now be after limit, but that's OK.
-			// We were going to write r.subst anyway. It just might be continued
-			// by an overlap.
-
-		default:
-			// This should only happen if bufidx = 0 and a previous flush
-			// moved earlier ranges before the start of the buffer.
-			// r.subst should have been written in the earlier flush.
-			bufidx = match.to
+	}
+	for _, w := range r.windows {
+		if w.regionEnd != nil && r.opts.unterminatedRegions == PassUnterminatedRegions {
+			continue
+		}
+		r.addCompleted(len(r.completedMatches), w.subrange)
+		if w.learn {
+			r.learn(w.subrange)
 		}
 	}
-
-	// Anything between here and limit?
-	if bufidx < limit {
-		if _, err := r.dst.Write(r.buf[bufidx:limit]); err != nil {
+	r.windows = r.windows[:0]
+	if debugInvariants {
+		checkSortedByTo(r.completedMatches, "flush")
+	}
+	r.reportMatches()
+	r.completedMatches = mergeOverlaps(r.completedMatches, r.opts.mergeAdjacent)
+	r.completedMatches = mergeGaps(r.completedMatches, r.opts.mergeGap)
+	r.partialMatches = append(r.partialMatches[:0], keep...)
+	r.carrying = len(keep) > 0
+	limit := len(r.buf)
+	for _, s := range keep {
+		if to := len(r.buf) - s.span(); to < limit {
+			limit = to
+		}
+	}
+	if err := r.flushUpTo(limit); err != nil {
+		return err
+	}
+	if r.ld != nil {
+		if err := r.ld.flush(); err != nil {
 			return err
 		}
-		bufidx = limit
 	}
+	if r.crc != nil {
+		if err := r.crc.flush(); err != nil {
+			return err
+		}
+	}
+	return r.flushOutput()
+}
 
-	// We got to the end of the buffer?
-	if bufidx >= len(r.buf) {
-		// Truncate the buffer, preserving capacity.
-		r.buf = r.buf[:0]
-
-		// All the redactions were also processed.
-		r.completedMatches = r.completedMatches[:0]
+// flushOutput writes any output buffered by WithBufferedOutput to r.dst.
+func (r *Redactor) flushOutput() error {
+	if r.bw == nil {
 		return nil
 	}
+	return r.bw.Flush()
+}
 
-	// Keep the remainder of the buffer where it is. A future append might
+// Sync writes as much of the buffered data as is known to be safe, like the
+// end of a Write. Unlike Flush, Sync does not end the stream. However, if the
+// redactor was created with WithSyncMaxAge, partial matches that began at least
+// that many Writes ago are first abandoned (treated as non-matches), so the
+// bytes they held back are written too. This bounds how much data can be held
+// back by adversarial or binary input, at the risk of leaking a secret that
+// is being written very slowly.
+func (r *Redactor) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.abandonStale(r.writes)
+	if err := r.flushUpTo(r.flushLimit()); err != nil {
+		return err
+	}
+	return r.flushOutput()
+}
+
+// abandonStale abandons partial matches that began at least opts.syncMaxAge
+// Writes before the given number of writes, if it is set.
+func (r *Redactor) abandonStale(writes int) {
+	maxAge := r.opts.syncMaxAge
+	if maxAge <= 0 {
+		return
+	}
+	kept := r.partialMatches[:0]
+	for _, s := range r.partialMatches {
+		// Word-bounded needles that have entirely matched are not stale,
+		// they are waiting for the next byte, and are kept.
+		if writes-s.since < maxAge || s.matched == len(s.needle.value) {
+			kept = append(kept, s)
+		}
+	}
+	r.partialMatches = kept
+}
+
+// armIdleFlush starts the timer for WithIdleFlush, if it is set, and there is
+// anything buffered. It replaces any timer started by an earlier Write.
+func (r *Redactor) armIdleFlush() {
+	if r.opts.idleFlush <= 0 {
+		return
+	}
+	if r.stopIdleFlush != nil {
+		r.stopIdleFlush()
+		r.stopIdleFlush = nil
+	}
+	if len(r.buf) == 0 {
+		return
+	}
+	writes := r.writes
+	r.stopIdleFlush = r.opts.afterFunc(r.opts.idleFlush, func() {
+		r.idleFlush(writes)
+	})
+}
+
+// idleFlush is called when there has been no Write for the WithIdleFlush
+// period after the given number of writes.
+func (r *Redactor) idleFlush(writes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.writes != writes {
+		// There was another Write after all, which started its own timer.
+		return
+	}
+	// The idle period counts as a Write towards WithSyncMaxAge.
+	r.abandonStale(writes + 1)
+	// Output the destination doesn't accept is kept for the next Write.
+	if err := r.flushUpTo(r.limit(true)); err == nil {
+		r.flushOutput()
+	}
+}
+
+// SetDestination writes as much of the buffered data as is known to be safe
+// to the current destination (like Sync, but without abandoning any partial
+// matches), and then switches to writing to dst. Data held back, such as a
+// partial match or a line held by WithCarriageReturnCollapse or the DropLine
+// policy, is written to the new destination, once it is known what to write. This is useful for
+// switching to a new file when rotating logs.
+//
+// The destination is switched even if writing to the old destination fails,
+// in which case the error is returned, and the output the old destination
+// didn't accept is written to the new one instead (except output buffered by
+// WithBufferedOutput, which is lost).
+func (r *Redactor) SetDestination(dst io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.flushUpTo(r.flushLimit())
+	if err == nil {
+		err = r.flushOutput()
+	}
+
+	r.dst = dst
+	var out io.Writer = dst
+	if r.hw != nil {
+		r.hw.dst = dst
+		out = r.hw
+	}
+	if r.bw != nil {
+		r.bw.Reset(out)
+		out = r.bw
+	}
+	if r.crc != nil {
+		r.crc.dst = out
+		out = r.crc
+	}
+	if r.ld != nil {
+		r.ld.dst = out
+	}
+	return err
+}
+
+// flush writes out the buffer up to an index. limit is an upper limit.
+func (r *Redactor) flushUpTo(limit int) error {
+	if err := r.writeUnwritten(); err != nil {
+		return err
+	}
+	if limit == 0 || len(r.buf) == 0 {
+		return nil
+	}
+	if r.opts.stallTimeout > 0 {
+		r.lastFlush = r.opts.now()
+	}
+
+	bufidx := 0 // where we are up to in the buffer
+	done := -1  // the index of the last match processed
+
+	// If writing fails, the input up to bufidx is still consumed (the output
+	// that wasn't written is kept by r.write), so stop there and update the
+	// buffer as usual. That way retrying doesn't duplicate or drop output.
+	var err error
+
+	// Stop when we're out of redactions, or the next one is after limit.
+matches:
+	for ri := 0; ri < len(r.completedMatches); ri++ {
+		match := r.completedMatches[ri]
+		if match.from >= limit {
+			// This range is after the cutoff point.
+			break
+		}
+
+		switch {
+		case bufidx < match.from:
+			// A non-redacted range (followed by a redacted range).
+			err = r.writeBuf(bufidx, match.from)
+			bufidx = match.from
+			if err != nil {
+				break matches
+			}
+			fallthrough
+
+		case bufidx == match.from:
+			// A redacted range.
+			if n := r.repeatRun(ri, limit); n > 1 {
+				// A run of repeated redactions, written as one.
+				run := r.completedMatches[ri : ri+n]
+				err = r.redactRun(run)
+				ri += n - 1
+				done = ri
+				bufidx = run[n-1].to
+				if ierr := r.writeBuf(bufidx, bufidx); err == nil {
+					err = ierr
+				}
+				if err != nil {
+					break matches
+				}
+				continue
+			}
+
+			// Write a r.subst instead of the redacted range.
+			err = r.redact(match)
+			done = ri
+			bufidx = match.to
+			if ierr := r.writeBuf(bufidx, bufidx); err == nil {
+				err = ierr
+			}
+			if err != nil {
+				break matches
+			}
+			// bufidx could now be after limit, but that's OK.
+			// We were going to write r.subst anyway. It just might be continued
+			// by an overlap.
+			// Either way, the whole substitution (whatever its length) is
+			// written now, and never split across flushes: the rest of the
+			// range, and any overlap that extends it later, is skipped by the
+			// default case below.
+
+		default:
+			// This should only happen if bufidx = 0 and a previous flush
+			// moved earlier ranges before the start of the buffer.
+			// r.subst should have been written in the earlier flush.
+			if r.opts.passSecrets() && bufidx < match.to {
+				// In dry-run mode, the rest of the range is written as-is.
+				err = r.writeBuf(bufidx, match.to)
+			}
+			done = ri
+			bufidx = match.to
+			if ierr := r.writeBuf(bufidx, bufidx); err == nil {
+				err = ierr
+			}
+			if err != nil {
+				break matches
+			}
+		}
+	}
+
+	// Anything between here and limit?
+	if err == nil && bufidx < limit {
+		err = r.writeBuf(bufidx, limit)
+		bufidx = limit
+	}
+
+	// We got to the end of the buffer?
+	if bufidx >= len(r.buf) {
+		r.offset += len(r.buf)
+		r.prevByte = r.buf[len(r.buf)-1]
+
+		// Truncate the buffer, preserving capacity.
+		r.buf = r.buf[:0]
+
+		// All the redactions were also processed.
+		r.completedMatches = r.completedMatches[:0]
+		return err
+	}
+
+	// Keep the remainder of the buffer where it is. A future append might
 	// create a new buffer, letting the old one be GC-ed.
+	if bufidx > 0 {
+		r.prevByte = r.buf[bufidx-1]
+	}
 	r.buf = r.buf[bufidx:]
+	r.offset += bufidx
 
 	// Because redactions refer to buffer positions, and the buffer shrank,
 	// update the redaction ranges to point at the correct locations in the
@@ -268,41 +1174,995 @@ func (r *Redactor) flushUpTo(limit int) error {
 		r.completedMatches[i] = match.sub(bufidx)
 	}
 	r.completedMatches = r.completedMatches[:rem]
+	for i := range r.windows {
+		r.windows[i].subrange = r.windows[i].sub(bufidx)
+	}
+	for i := range r.inserts {
+		r.inserts[i].at -= bufidx
+	}
+
+	return err
+}
 
+// insertion is output to write as is, once the buffer before at is written.
+type insertion struct {
+	at int
+	b  []byte
+}
+
+// insert writes b to the output as is, without redacting it, after the input
+// written so far (once the redactor writes it out). It is used to write
+// recompressed gzip members (see GzipTransparent) in place, without cutting
+// short the matches the redactor is in the middle of.
+func (r *Redactor) insert(b []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.buf) > 0 {
+		r.inserts = append(r.inserts, insertion{at: len(r.buf), b: b})
+		return nil
+	}
+	if err := r.write(b); err != nil {
+		return err
+	}
+	return r.flushOutput()
+}
+
+// writeBuf writes r.buf[from:to], along with the insertions up to to, in
+// place. Like write, it keeps the output it couldn't write, so it writes all
+// of it even after an error, which it returns.
+func (r *Redactor) writeBuf(from, to int) error {
+	var err error
+	keep := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	for len(r.inserts) > 0 && r.inserts[0].at <= to {
+		if at := r.inserts[0].at; at > from {
+			keep(r.write(r.buf[from:at]))
+			from = at
+		}
+		keep(r.write(r.inserts[0].b))
+		r.inserts = r.inserts[1:]
+	}
+	if from < to {
+		keep(r.write(r.buf[from:to]))
+	}
+	return err
+}
+
+// complete handles a partial match that has matched its entire needle, with
+// the final byte of the needle at bufidx.
+func (r *Redactor) complete(s partialMatch, bufidx int) {
+	from := bufidx - len(s.needle.value) - s.skipped + 1
+
+	if s.needle.wordBoundary {
+		// The byte before the match has to be a non-word byte.
+		if c, ok := r.byteBefore(from); ok && isWordByte(c) {
+			return
+		}
+		// The byte after the match has to be a non-word byte too, but it
+		// hasn't been seen yet. Keep it as a partial match until then.
+		r.nextMatches = append(r.nextMatches, s)
+		return
+	}
+
+	r.completeRange(len(r.completedMatches), subrange{
+		from:   from,
+		to:     bufidx + 1,
+		needle: s.needle,
+	})
+}
+
+// completeRange records a range to redact, inserting it into
+// r.completedMatches at index i. If the needle has a context window, the range
+// is widened by the window before it, and if there is a window after it, kept
+// in r.windows to be widened further. Likewise, a region start marker opens a
+// region in r.windows, and a match after a quote (see WithSurroundingQuotes)
+// waits in r.windows for the closing quote.
+func (r *Redactor) completeRange(i int, match subrange) {
+	if end := match.needle.regionEnd; end != nil {
+		r.windows = append(r.windows, contextWindow{subrange: match, regionEnd: end})
+		return
+	}
+	if r.opts.firstOccurrenceOnly {
+		if r.redactedOnce[match.needle.value] {
+			return
+		}
+		if r.redactedOnce == nil {
+			r.redactedOnce = make(map[string]bool)
+		}
+		r.redactedOnce[match.needle.value] = true
+	}
+	// A match can start before the buffer, if it overlaps a substitution
+	// that has already been written (see flushUpTo). Then there is nothing
+	// before it left to widen it over.
+	if before := match.needle.contextBefore; before > 0 && match.from > 0 {
+		from := match.from - before
+		if from < 0 {
+			from = 0
+		}
+		if nl := bytes.LastIndexByte(r.buf[from:match.from], '\n'); nl >= 0 {
+			from += nl + 1
+		}
+		match.from = from
+	}
+	if after := match.needle.contextAfter; after > 0 {
+		r.windows = append(r.windows, contextWindow{subrange: match, remaining: after})
+		return
+	}
+	if window := r.opts.learnWindow; window > 0 && match.needle.learnable() {
+		// Widen the match to the token around it, and learn the token once
+		// it ends.
+		from := match.from
+		for from > 0 && match.from-from < window && isTokenByte(r.buf[from-1]) {
+			from--
+		}
+		match.from = from
+		r.windows = append(r.windows, contextWindow{subrange: match, remaining: window, learn: true})
+		return
+	}
+	if r.opts.surroundingQuotes && match.from > 0 && isQuote(r.buf[match.from-1]) {
+		// Widen the match over the quotes if the next byte closes them.
+		r.windows = append(r.windows, contextWindow{subrange: match, quote: r.buf[match.from-1]})
+		return
+	}
+	r.addCompleted(i, match)
+}
+
+// addCompleted inserts match into r.completedMatches at index i, and keeps it
+// for WithOnMatches.
+func (r *Redactor) addCompleted(i int, match subrange) {
+	r.completedMatches = insertRange(r.completedMatches, i, match)
+	if r.matchesByFingerprint == nil {
+		r.matchesByFingerprint = make(map[string]int)
+	}
+	r.matchesByFingerprint[match.needle.fingerprint]++
+	if r.opts.onMatches != nil {
+		r.newMatches = append(r.newMatches, match)
+	}
+}
+
+// reportMatches calls the WithOnMatches callback with the ranges completed
+// since it was last called, if any, before they are merged.
+func (r *Redactor) reportMatches() {
+	if r.opts.onMatches == nil || len(r.newMatches) == 0 {
+		return
+	}
+	rs := make([]ranges.Range, 0, len(r.newMatches))
+	for _, m := range r.newMatches {
+		rs = append(rs, ranges.Range{From: r.offset + m.from, To: r.offset + m.to})
+	}
+	r.newMatches = r.newMatches[:0]
+	r.opts.onMatches(rs)
+}
+
+// widenWindows widens each context window waiting for the byte c at bufidx to
+// include it. Windows that end before c (because it is a line break, or the
+// window is full) are inserted into r.completedMatches at index i.
+func (r *Redactor) widenWindows(c byte, bufidx, i int) {
+	kept := r.windows[:0]
+	for _, w := range r.windows {
+		if w.to != bufidx {
+			// Opened on this byte, so it already includes it.
+			kept = append(kept, w)
+			continue
+		}
+		if w.regionEnd != nil {
+			// Regions span lines, and end after the end marker (which can't
+			// overlap the start marker).
+			w.to++
+			if w.to-w.from >= len(w.needle.value)+len(w.regionEnd) && bytes.HasSuffix(r.buf[:w.to], w.regionEnd) {
+				r.addCompleted(i, w.subrange)
+				continue
+			}
+			kept = append(kept, w)
+			continue
+		}
+		if w.quote != 0 {
+			if c == w.quote {
+				w.from--
+				w.to++
+			}
+			r.addCompleted(i, w.subrange)
+			continue
+		}
+		if c == '\n' || w.remaining == 0 || (w.learn && !isTokenByte(c)) {
+			r.addCompleted(i, w.subrange)
+			if w.learn {
+				r.learn(w.subrange)
+			}
+			continue
+		}
+		w.to++
+		w.remaining--
+		kept = append(kept, w)
+	}
+	r.windows = kept
+}
+
+// learn adds the token in the range as a needle, if it is not already one.
+// If that makes too many learned needles, the oldest is forgotten.
+func (r *Redactor) learn(token subrange) {
+	if token.from < 0 {
+		// The start of the token has already been written.
+		return
+	}
+	value := string(r.buf[token.from:token.to])
+	if r.needles.has(value) {
+		return
+	}
+	r.learned = append(r.learned, value)
+	var forget string
+	if len(r.learned) > r.opts.learnMax {
+		forget = r.learned[0]
+		r.learned = r.learned[1:]
+	}
+	r.setNeedles(r.needles.with(func(n *needle) bool {
+		return n.value != forget
+	}, []Needle{{Value: value}}))
+}
+
+// isTokenByte reports whether c can be part of a token learned by WithLearning.
+// Tokens are delimited by whitespace, control characters, quotes, brackets,
+// and some punctuation.
+func isTokenByte(c byte) bool {
+	if c <= ' ' || c == 0x7f {
+		return false
+	}
+	return !strings.ContainsRune("\"'`()[]{}<>,;=", rune(c))
+}
+
+// isQuote reports whether c is a quote that WithSurroundingQuotes redacts
+// around a secret.
+func isQuote(c byte) bool {
+	return c == '"' || c == '\''
+}
+
+// byteBefore returns the byte in the stream before r.buf[i], which may have
+// already been flushed. It returns false at the start of the stream.
+func (r *Redactor) byteBefore(i int) (byte, bool) {
+	if i > 0 {
+		return r.buf[i-1], true
+	}
+	return r.prevByte, r.offset > 0
+}
+
+// replacement returns the bytes to write in place of a redacted range.
+func (r *Redactor) replacement(match subrange) []byte {
+	if match.needle != nil && match.needle.replacement != nil {
+		return match.needle.replacement
+	}
+	if r.opts.substFunc != nil {
+		meta := MatchMeta{Length: match.to - match.from}
+		if match.needle != nil {
+			meta.Fingerprint = match.needle.fingerprint
+		}
+		// Any part of the range before the buffer was covered by a
+		// substitution already written.
+		start := match.from
+		if start < 0 {
+			start = 0
+		}
+		for i, c := range r.buf[start:match.to] {
+			if c == '\n' {
+				meta.LineBreaks = append(meta.LineBreaks, start-match.from+i)
+			}
+		}
+		return r.opts.substFunc(meta)
+	}
+	return r.subst
+}
+
+// redact writes the substitution for a redacted range of the buffer (or in
+// dry-run mode, the range itself), and records the redaction.
+func (r *Redactor) redact(match subrange) error {
+	out := r.replacement(match)
+	switch {
+	case r.opts.passSecrets():
+		out = r.buf[match.from:match.to]
+	case r.ld != nil:
+		r.ld.drop()
+		out = nil
+	}
+	outOffset := r.written
+	err := r.write(out)
+	r.recordRedaction(match)
+	r.mapOffsets(match, outOffset)
+	if serr := r.writeSidecar(match, outOffset); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// redactRun writes a single substitution for a run of repeated redactions,
+// and records each redaction.
+func (r *Redactor) redactRun(run []subrange) error {
+	outOffset := r.written
+	out := collapsedReplacement(r.replacement(run[0]), len(run))
+	if r.ld != nil {
+		r.ld.drop()
+		out = nil
+	}
+	err := r.write(out)
+	for _, match := range run {
+		r.recordRedaction(match)
+	}
+	whole := subrange{from: run[0].from, to: run[len(run)-1].to, needle: run[0].needle}
+	r.mapOffsets(whole, outOffset)
+	if serr := r.writeSidecar(whole, outOffset); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// writeSidecar writes a SidecarRecord for a substitution written at outOffset
+// in place of match, if there is a sidecar.
+func (r *Redactor) writeSidecar(match subrange, outOffset int) error {
+	if r.opts.sidecar == nil {
+		return nil
+	}
+	rec := SidecarRecord{
+		InputOffset:  r.offset + match.from,
+		InputLength:  match.to - match.from,
+		OutputOffset: outOffset,
+		OutputLength: r.written - outOffset,
+	}
+	if match.needle != nil {
+		rec.Fingerprint = match.needle.fingerprint
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = r.opts.sidecar.Write(append(b, '\n'))
+	return err
+}
+
+// write writes b to the destination, keeping count of bytes written.
+//
+// The input that b was produced from is consumed regardless of errors: if
+// the destination returns an error, the part of b it didn't accept is kept in
+// r.unwritten, and written before any other output.
+func (r *Redactor) write(b []byte) error {
+	r.written += len(b)
+	if err := r.writeUnwritten(); err != nil {
+		r.unwritten = append(r.unwritten, b...)
+		return err
+	}
+	n, err := r.out().Write(b)
+	if err != nil {
+		r.unwritten = append(r.unwritten, b[n:]...)
+	}
+	return err
+}
+
+// writeUnwritten writes output left unwritten by an earlier error.
+func (r *Redactor) writeUnwritten() error {
+	if len(r.unwritten) == 0 {
+		return nil
+	}
+	n, err := r.out().Write(r.unwritten)
+	if err != nil {
+		// Keep the remainder at the start, to reuse the array.
+		r.unwritten = r.unwritten[:copy(r.unwritten, r.unwritten[n:])]
+		return err
+	}
+	r.unwritten = r.unwritten[:0]
 	return nil
 }
 
+// out returns the writer that output is written to.
+func (r *Redactor) out() io.Writer {
+	switch {
+	case r.ld != nil:
+		return r.ld
+	case r.crc != nil:
+		return r.crc
+	case r.bw != nil:
+		return r.bw
+	case r.hw != nil:
+		return r.hw
+	}
+	return r.dst
+}
+
+// hashWriter writes to dst, and adds the bytes dst accepts to h.
+type hashWriter struct {
+	dst io.Writer
+	h   hash.Hash
+}
+
+func (w *hashWriter) Write(b []byte) (int, error) {
+	n, err := w.dst.Write(b)
+	// Writing to a hash.Hash never returns an error.
+	w.h.Write(b[:n])
+	return n, err
+}
+
+// mapOffsets calls the OffsetMapping callback for a substitution written at
+// outOffset in place of match.
+func (r *Redactor) mapOffsets(match subrange, outOffset int) {
+	if r.opts.onOffsetMapping == nil {
+		return
+	}
+	r.opts.onOffsetMapping(OffsetMapping{
+		InputOffset:  r.offset + match.from,
+		InputLength:  match.to - match.from,
+		OutputOffset: outOffset,
+		OutputLength: r.written - outOffset,
+	})
+}
+
+// recordRedaction updates stats and calls the OnRedact callback for a range
+// that has been redacted.
+func (r *Redactor) recordRedaction(match subrange) {
+	r.redactions++
+	r.redactedBytes += match.to - match.from
+	if r.opts.onRedact != nil {
+		r.opts.onRedact(Redaction{
+			Offset:      r.offset + match.from,
+			Length:      match.to - match.from,
+			Fingerprint: match.needle.fingerprint,
+		})
+	}
+}
+
+// repeatRun returns the length of the run of repeated redactions starting at
+// r.completedMatches[i] that should be collapsed into one substitution, or 0
+// if there is no such run. Only ranges starting before limit are considered.
+func (r *Redactor) repeatRun(i, limit int) int {
+	if r.opts.collapseRepeats == 0 || r.opts.passSecrets() {
+		return 0
+	}
+	n := r.sameRun(i, +1, limit)
+	if n < r.opts.collapseRepeats {
+		return 0
+	}
+	return n
+}
+
+// sameRun returns the number of consecutive ranges in r.completedMatches,
+// starting at index i and stepping by dir (+1 or -1), that have the same
+// replacement and are separated only by whitespace. Only ranges starting
+// before limit are considered.
+func (r *Redactor) sameRun(i, dir, limit int) int {
+	n := 1
+	for {
+		a, b := i, i+dir
+		if dir < 0 {
+			a, b = b, a
+		}
+		if a < 0 || b >= len(r.completedMatches) {
+			return n
+		}
+		prev, next := r.completedMatches[a], r.completedMatches[b]
+		if next.from >= limit ||
+			!bytes.Equal(r.replacement(prev), r.replacement(next)) ||
+			!isAllSpace(r.buf[prev.to:next.from]) {
+			return n
+		}
+		n++
+		i += dir
+	}
+}
+
+// collapsedReplacement returns the replacement used for a run of n repeated
+// redactions, e.g. "[REDACTED ×5]" for "[REDACTED]".
+func collapsedReplacement(repl []byte, n int) []byte {
+	count := fmt.Sprintf(" ×%d", n)
+	if len(repl) > 0 && repl[len(repl)-1] == ']' {
+		return []byte(string(repl[:len(repl)-1]) + count + "]")
+	}
+	return []byte(string(repl) + count)
+}
+
+// isAllSpace reports whether b consists only of whitespace.
+func isAllSpace(b []byte) bool {
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns an error if the redactor's substitution (or any
+// per-needle replacement) contains any of its needles, as ValidateSubst does.
+// The check is made whenever the needles change (by New, Reset, AddNeedle,
+// and so on), so Validate is cheap. The problem is also logged, if a logger
+// is set with WithLogger.
+func (r *Redactor) Validate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.substErr
+}
+
+// Clone returns a new Redactor writing to dst, with the same substitution,
+// needles, and options as r (except WithHash, since the clone's output is
+// separate). The clone does not share any buffered data or
+// in-progress matches with r, and can be used independently.
+func (r *Redactor) Clone(dst io.Writer) *Redactor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opts := r.opts
+	opts.hash = nil
+	return r.clone(dst, opts)
+}
+
+// clone is Clone, with the given options instead of r's. r.mu must be held.
+func (r *Redactor) clone(dst io.Writer, opts options) *Redactor {
+	c := newRedactor(dst, string(r.subst), nil)
+	c.opts = opts
+	c.setupOutput()
+	c.regions = append([]Needle(nil), r.regions...)
+	// The clone has the same substitution and needles, so its check has the
+	// same result, which needn't be logged again.
+	c.substErr = r.substErr
+	c.setNeedles(r.needles)
+	for v, expiry := range r.expiries {
+		if c.expiries == nil {
+			c.expiries = make(map[string]time.Time, len(r.expiries))
+		}
+		c.expiries[v] = expiry
+	}
+	return c
+}
+
+// WouldRedact reports whether r would redact anything in s, were s written to
+// it on its own and flushed. It is meant for checking configuration, such as
+// whether a value will be caught by the current needles, and doesn't affect
+// r: s is matched by a scratch copy of r with the same needles and options,
+// which discards its output and doesn't call any callbacks (such as
+// WithOnRedact) or write to a sidecar. Input buffered by r isn't taken into
+// account, so a needle that only partly appears at either end of s doesn't
+// count.
+func (r *Redactor) WouldRedact(s string) bool {
+	c, _ := r.scratch(io.Discard)
+	c.Write([]byte(s))
+	c.Flush()
+	return c.redactions > 0
+}
+
+// scratch returns a copy of r writing to dst, for redacting values on their
+// own without affecting r, along with the needles it was copied with. It has
+// r's needles and options, except for those with side effects (callbacks, the
+// sidecar, timers, and the hash), and WithFirstOccurrenceOnly, so that a
+// secret is redacted in every value.
+func (r *Redactor) scratch(dst io.Writer) (*Redactor, *NeedleSet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opts := r.opts
+	opts.onRedact = nil
+	opts.onOffsetMapping = nil
+	opts.onMatches = nil
+	opts.sidecar = nil
+	opts.stallTimeout = 0
+	opts.idleFlush = 0
+	opts.hash = nil
+	opts.firstOccurrenceOnly = false
+	return r.clone(dst, opts), r.needles
+}
+
+// currentNeedles returns r's needles, which change when it is Reset.
+func (r *Redactor) currentNeedles() *NeedleSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.needles
+}
+
+// Pending returns the number of bytes held in the buffer, and the number of
+// partial matches holding them back. It is cheaper than Stats, and useful for
+// detecting a stream stuck behind an incomplete secret.
+func (r *Redactor) Pending() (bytes, partialMatches int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.buf), len(r.partialMatches)
+}
+
+// MaxNeedleLength returns the length in bytes of the longest needle the
+// redactor currently redacts (including region start markers), or 0 if there
+// are none. This is about the most a partial match holds back in Write, which
+// is useful for sizing buffers and stall timeouts. (A needle with delimiters
+// can hold back more, by the delimiters skipped within a match.) It doesn't
+// include needles added by AddNeedleAsync that haven't been added yet.
+func (r *Redactor) MaxNeedleLength() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.needles.maxLen
+}
+
+// Stats returns statistics about the redactor.
+func (r *Redactor) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats()
+}
+
+// stats does the work of Stats.
+func (r *Redactor) stats() Stats {
+	return Stats{
+		Redactions:   r.redactions,
+		PeakBuffered: r.peakBuffered,
+		Buffered:     len(r.buf),
+		Processed:    r.offset + len(r.buf) - r.processedBase,
+	}
+}
+
+// ResetStats zeroes the redactor's Stats, and the counts in its Manifest
+// (except Buffered, and PeakBuffered, which becomes the number of bytes
+// buffered now). Stats are otherwise
+// cumulative for the life of the redactor: Reset and its variants replace the
+// secrets, but keep the Stats, so totals remain accurate across secret
+// rotations. Since counters registered with RegisterMetrics must never go
+// down, don't call ResetStats on a redactor with registered metrics.
+func (r *Redactor) ResetStats() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.redactions = 0
+	r.redactedBytes = 0
+	r.matchesByFingerprint = nil
+	r.peakBuffered = len(r.buf)
+	r.processedBase = r.offset + len(r.buf)
+}
+
+// ResetStream prepares the redactor for a new, independent stream, such as
+// the log of the next job, so that a redactor can be pooled and reused
+// rather than created for each stream. It keeps the needles (including any
+// learned with WithLearning), substitution, options, destination (see
+// SetDestination), and Stats (see ResetStats).
+//
+// Anything held from the current stream is discarded without being written:
+// buffered input, partial matches, output the destination didn't accept, and
+// lines held by WithCarriageReturnCollapse or the DropLine policy. Call Flush
+// first to write them out. Offsets, such as those reported to WithOnRedact,
+// start again from 0, and WithFirstOccurrenceOnly redacts the first
+// occurrence of each secret in the new stream.
+func (r *Redactor) ResetStream() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Processed is cumulative, so it must not go back with the offset.
+	r.processedBase -= r.offset + len(r.buf)
+
+	r.buf = r.buf[:0]
+	r.partialMatches = r.partialMatches[:0]
+	r.nextMatches = r.nextMatches[:0]
+	r.completedMatches = r.completedMatches[:0]
+	r.newMatches = r.newMatches[:0]
+	r.windows = r.windows[:0]
+	r.unwritten = r.unwritten[:0]
+	r.inserts = nil
+	r.offset = 0
+	r.prevByte = 0
+	r.written = 0
+	r.column = 0
+	r.wrapped = false
+	r.carrying = false
+	r.redactedOnce = nil
+	r.lastFlush = time.Time{}
+	if r.stopIdleFlush != nil {
+		r.stopIdleFlush()
+		r.stopIdleFlush = nil
+	}
+	r.setupOutput()
+}
+
+// String returns a summary of the redactor for diagnostics, which doesn't
+// include any needles, buffered data, or the substitution (only its length).
+// This way a redactor that ends up in a log line doesn't leak secrets.
+func (r *Redactor) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return fmt.Sprintf("Redactor{needles:%d, buffered:%d, subst_len:%d}", r.needles.len, len(r.buf), len(r.subst))
+}
+
+// GoString is like String, so that formatting with %#v doesn't leak secrets
+// either.
+func (r *Redactor) GoString() string {
+	return "&redactor." + r.String()
+}
+
 // Reset replaces the secrets to redact with a new set of secrets. It is not
 // necessary to Flush beforehand, but:
 //   - any previous secrets which have begun matching will continue matching
 //     (until they reach a terminal state), and
 //   - any new secrets will not be compared against existing buffer content,
 //     only data passed to Write calls after Reset.
+//
+// If only a few secrets have changed since the last Reset, only the buckets of
+// needles that changed are rebuilt. Stats are kept (see ResetStats). As with
+// New, needles shorter than RedactLengthMin are redacted too.
 func (r *Redactor) Reset(needles []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	for i := range r.needlesByFirstByte {
-		r.needlesByFirstByte[i] = nil
+	r.setNeedles(r.opts.rebuildNeedleSet(r.needles, needles))
+	r.expiries = nil
+	r.learned = nil
+	r.redactedOnce = nil
+}
+
+// resetWithSubst is like Reset, but also replaces the substitution with subst.
+func (r *Redactor) resetWithSubst(subst string, needles []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The substitution is set first, so that it is checked against the new
+	// needles.
+	r.subst = []byte(subst)
+	r.setNeedles(r.opts.rebuildNeedleSet(r.needles, needles))
+	r.expiries = nil
+	r.learned = nil
+	r.redactedOnce = nil
+}
+
+// ResetBytes is like Reset, but the needles are given as byte slices. As with
+// NewFromBytes, the needles are copied, so the caller may zero its slices once
+// ResetBytes returns.
+func (r *Redactor) ResetBytes(needles [][]byte) {
+	r.Reset(copyNeedles(needles))
+}
+
+// ResetSource is like Reset, but pulls the needles from src. Needles are
+// bucketed as src provides them, so they never need to be collected into a
+// slice first.
+func (r *Redactor) ResetSource(src NeedleSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.setNeedles(r.opts.needleSet(src))
+	r.expiries = nil
+	r.learned = nil
+	r.redactedOnce = nil
+}
+
+// ResetNeedleSet is like Reset, but uses a pre-built NeedleSet. Options that
+// derive extra needles from each secret are not applied to the NeedleSet.
+func (r *Redactor) ResetNeedleSet(needles *NeedleSet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.setNeedles(needles)
+	r.expiries = nil
+	r.learned = nil
+	r.redactedOnce = nil
+}
+
+// AddNeedle adds a secret to redact, along with any needles derived from it by
+// the enabled options. Like Reset, the new secret is only compared against
+// data passed to Write calls after AddNeedle. If the redactor shares a
+// NeedleSet with other redactors, they are unaffected.
+func (r *Redactor) AddNeedle(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expireNeedles()
+	r.addNeedle(s, time.Time{})
+}
+
+// AddNeedleWithTTL is like AddNeedle, but the secret is removed again once d
+// has elapsed, for a secret that will be rotated. Expiry is checked at the
+// start of each Write, rather than with a timer. Matches of the secret that
+// began before it expired are still completed (and redacted). If the secret
+// is already being redacted without a TTL, it is left without a TTL; if it
+// already has a TTL, the TTL is replaced. Reset removes all added secrets.
+func (r *Redactor) AddNeedleWithTTL(s string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expireNeedles()
+	r.addNeedle(s, r.opts.now().Add(d))
+}
+
+// AddNeedleAsync is like AddNeedle, but doesn't wait for a Write (or Flush)
+// in progress, so it can be called by a goroutine discovering secrets without
+// blocking on output. The secret is added at the start of the next Write, so
+// it applies to all data passed to Writes that begin after AddNeedleAsync
+// returns.
+func (r *Redactor) AddNeedleAsync(s string) {
+	r.feedMu.Lock()
+	defer r.feedMu.Unlock()
+
+	r.fed = append(r.fed, s)
+}
+
+// addFedNeedles adds the needles passed to AddNeedleAsync since it was last
+// called.
+func (r *Redactor) addFedNeedles() {
+	r.feedMu.Lock()
+	fed := r.fed
+	r.fed = nil
+	r.feedMu.Unlock()
+
+	if len(fed) > 0 {
+		r.addNeedles(fed, time.Time{})
 	}
-	for _, s := range needles {
-		if len(s) == 0 {
-			continue
+}
+
+// AddRegion causes everything from each occurrence of startMarker up to the
+// next occurrence of endMarker to be redacted, including the markers, whether
+// or not it contains any needles. This is for tools that bracket secret output
+// with markers (e.g. "@@SECRET_START@@" and "@@SECRET_END@@"). Markers split
+// across Writes are detected. Unlike needles, regions are kept when the
+// redactor is Reset.
+//
+// Output from the start marker onwards is held back until the end marker is
+// written, or until Flush, which handles any region still open according to
+// WithUnterminatedRegions (by default, it is redacted to the end of the
+// stream). Empty markers are ignored.
+func (r *Redactor) AddRegion(startMarker, endMarker []byte) {
+	if len(startMarker) == 0 || len(endMarker) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	region := Needle{
+		Value:     string(startMarker),
+		regionEnd: append([]byte(nil), endMarker...),
+	}
+	r.regions = append(r.regions, region)
+	r.setNeedles(r.needles.with(nil, []Needle{region}))
+}
+
+// addNeedle adds the needles for s, expiring at expiry (unless zero).
+func (r *Redactor) addNeedle(s string, expiry time.Time) {
+	r.addNeedles([]string{s}, expiry)
+}
+
+// addNeedles adds the needles for each of values, expiring at expiry (unless
+// zero), by making a single new NeedleSet.
+func (r *Redactor) addNeedles(values []string, expiry time.Time) {
+	var add []Needle
+	r.opts.expandNeedles(NeedleSlice(values), func(n Needle) {
+		_, hasTTL := r.expiries[n.Value]
+		present := r.needles.has(n.Value)
+		switch {
+		case expiry.IsZero():
+			delete(r.expiries, n.Value)
+		case hasTTL || !present:
+			if r.expiries == nil {
+				r.expiries = make(map[string]time.Time)
+			}
+			r.expiries[n.Value] = expiry
+		}
+		if !present {
+			add = append(add, n)
+		}
+	})
+	if len(add) > 0 {
+		r.setNeedles(r.needles.with(nil, add))
+	}
+}
+
+// expireNeedles removes needles added by AddNeedleWithTTL that have expired.
+func (r *Redactor) expireNeedles() {
+	if len(r.expiries) == 0 {
+		return
+	}
+	now := r.opts.now()
+	expired := make(map[string]bool)
+	for v, expiry := range r.expiries {
+		if !now.Before(expiry) {
+			expired[v] = true
+			delete(r.expiries, v)
 		}
-		r.needlesByFirstByte[s[0]] = append(r.needlesByFirstByte[s[0]], s)
+	}
+	if len(expired) == 0 {
+		return
+	}
+	r.setNeedles(r.needles.with(func(n *needle) bool {
+		return !expired[n.value]
+	}, nil))
+}
+
+// setNeedles replaces the needles.
+func (r *Redactor) setNeedles(needles *NeedleSet) {
+	if needles == nil {
+		needles = emptyNeedleSet
+	}
+	if needles.regions < len(r.regions) {
+		// Keep the regions when resetting needles.
+		needles = needles.with(nil, r.regions)
+	}
+	r.needles = needles
+
+	// A substitution containing a needle would write it out, so check for
+	// that whenever the needles change. Only warn when it starts happening,
+	// so that adding more needles doesn't repeat the warning.
+	err := ValidateSubst(string(r.subst), needles)
+	if err != nil && r.substErr == nil && r.opts.logger != nil {
+		r.opts.logger.Warningf("Some secrets may not be redacted from output: %v", err)
+	}
+	r.substErr = err
+
+	// Preallocate the slices used for matching, so they don't need to grow
+	// while writing. If there are now fewer needles, the existing slices are
+	// big enough already.
+	if r.partialMatches == nil || cap(r.partialMatches) < needles.len {
+		partialMatches := make([]partialMatch, len(r.partialMatches), needles.len)
+		copy(partialMatches, r.partialMatches)
+		r.partialMatches = partialMatches
+	}
+	if r.nextMatches == nil || cap(r.nextMatches) < needles.len {
+		nextMatches := make([]partialMatch, len(r.nextMatches), needles.len)
+		copy(nextMatches, r.nextMatches)
+		r.nextMatches = nextMatches
+	}
+	if r.completedMatches == nil || cap(r.completedMatches) < needles.len {
+		completedMatches := make([]subrange, len(r.completedMatches), needles.len)
+		copy(completedMatches, r.completedMatches)
+		r.completedMatches = completedMatches
 	}
 }
 
 // partialMatch tracks how far through one of the needles we have matched.
 type partialMatch struct {
-	needle  string
+	needle  *needle
 	matched int
+
+	// Number of the needle's delimiters (or line feeds wrapping it, see
+	// WithUnwrapColumn) skipped within the match so far.
+	skipped int
+
+	// The value of writes when the match began.
+	since int
+}
+
+// span returns the number of bytes of input the match covers so far.
+func (s partialMatch) span() int {
+	return s.matched + s.skipped
+}
+
+// contextWindow is a match of a needle with a context window after it, which
+// is widened by each byte written until the window is full or a line ends.
+type contextWindow struct {
+	subrange
+
+	// Number of bytes the window can still be widened by.
+	remaining int
+
+	// If set, the window is a token to learn (see WithLearning), and ends
+	// at the first byte that is not a token byte.
+	learn bool
+
+	// If not nil, the window is a region (see AddRegion), and ends after
+	// this end marker.
+	regionEnd []byte
+
+	// If not 0, the window is a match preceded by this quote (see
+	// WithSurroundingQuotes), and ends at the next byte, widened over both
+	// quotes if that byte is the same quote.
+	quote byte
 }
 
 // subrange designates a contiguous range in a buffer (slice indexes: inclusive
 // of from, exclusive of to).
 type subrange struct {
 	from, to int
+
+	// The needle that provides the replacement for the range. For ranges
+	// formed by merging overlaps, this is the preferred needle among them.
+	needle *needle
+}
+
+// bounds returns the range without its needle.
+func (r subrange) bounds() ranges.Range {
+	return ranges.Range{From: r.from, To: r.to}
 }
 
 func (r subrange) sub(x int) subrange {
@@ -311,30 +2171,39 @@ func (r subrange) sub(x int) subrange {
 	return r
 }
 
-// contains reports if the range contains x.
-func (r subrange) contains(x int) bool {
-	return r.from <= x && x < r.to
-}
-
 // overlap reports if the two ranges overlap in any way.
 func (r subrange) overlap(s subrange) bool {
-	return r.contains(s.from) || s.contains(r.from)
+	return r.bounds().Overlap(s.bounds())
+}
+
+// adjacent reports if one range begins exactly where the other ends.
+func (r subrange) adjacent(s subrange) bool {
+	return r.bounds().Adjacent(s.bounds())
 }
 
 // union returns a range containing both r and s.
+// The needle of s is kept, unless the needle of r is preferred over it.
 func (r subrange) union(s subrange) subrange {
-	if r.from < s.from {
-		s.from = r.from
-	}
-	if r.to > s.to {
-		s.to = r.to
+	u := r.bounds().Union(s.bounds())
+	s.from, s.to = u.From, u.To
+	if r.needle.preferredOver(s.needle) {
+		s.needle = r.needle
 	}
 	return s
 }
 
-// mergeOverlaps combines overlapping ranges. It alters the contents of the
-// input, and assumes the ranges are sorted by "to".
-func mergeOverlaps(rs []subrange) []subrange {
+// insertRange inserts a range into rs at index i.
+func insertRange(rs []subrange, i int, x subrange) []subrange {
+	rs = append(rs, subrange{})
+	copy(rs[i+1:], rs[i:])
+	rs[i] = x
+	return rs
+}
+
+// mergeOverlaps combines overlapping ranges, and if adjacent is true, also
+// ranges that are adjacent. It alters the contents of the input, and assumes
+// the ranges are sorted by "to".
+func mergeOverlaps(rs []subrange, adjacent bool) []subrange {
 	// If there are none, or only one, then it's already merged.
 	if len(rs) <= 1 {
 		return rs
@@ -344,7 +2213,7 @@ func mergeOverlaps(rs []subrange) []subrange {
 	// each rs[i] into rs[j].
 	j := len(rs) - 1
 	for i := j - 1; i >= 0; i-- {
-		if rs[j].overlap(rs[i]) {
+		if rs[j].overlap(rs[i]) || (adjacent && rs[j].adjacent(rs[i])) {
 			rs[j] = rs[j].union(rs[i])
 		} else {
 			j--
@@ -358,6 +2227,25 @@ func mergeOverlaps(rs []subrange) []subrange {
 	return rs[:rem]
 }
 
+// mergeGaps merges ranges separated by no more than gap bytes, including the
+// bytes between them. It alters the contents of the input, and assumes the
+// ranges are sorted and don't overlap, as returned by mergeOverlaps.
+func mergeGaps(rs []subrange, gap int) []subrange {
+	if gap <= 0 || len(rs) <= 1 {
+		return rs
+	}
+	j := 0
+	for i := 1; i < len(rs); i++ {
+		if rs[i].from-rs[j].to <= gap {
+			rs[j] = rs[j].union(rs[i])
+			continue
+		}
+		j++
+		rs[j] = rs[i]
+	}
+	return rs[:j+1]
+}
+
 // ValuesToRedact returns the variable values to be redacted, given a
 // redaction config string and an environment map.
 func ValuesToRedact(logger shell.Logger, patterns []string, environment map[string]string) []string {
@@ -374,13 +2262,223 @@ func ValuesToRedact(logger shell.Logger, patterns []string, environment map[stri
 	return vals
 }
 
+// ValuesToRedactStripped is like ValuesToRedact, but for each value that
+// starts with one of prefixes or ends with one of suffixes (such as "Bearer "
+// for a value stored as an Authorization header), it also returns the value
+// with them stripped, if that is still at least RedactLengthMin bytes long.
+// Secrets are often logged without the wrapper they are stored with. At most
+// one prefix and one suffix is stripped (the first of each that matches,
+// case-sensitively).
+func ValuesToRedactStripped(logger shell.Logger, patterns []string, environment map[string]string, prefixes, suffixes []string) []string {
+	vals := ValuesToRedact(logger, patterns, environment)
+	for _, val := range vals {
+		stripped := val
+		for _, prefix := range prefixes {
+			if prefix != "" && strings.HasPrefix(stripped, prefix) {
+				stripped = stripped[len(prefix):]
+				break
+			}
+		}
+		for _, suffix := range suffixes {
+			if suffix != "" && strings.HasSuffix(stripped, suffix) {
+				stripped = stripped[:len(stripped)-len(suffix)]
+				break
+			}
+		}
+		if stripped != val && len(stripped) >= RedactLengthMin {
+			vals = append(vals, stripped)
+		}
+	}
+	return vals
+}
+
 // VarsToRedact returns the variable names and values to be redacted, given a
 // redaction config string and an environment map.
 func VarsToRedact(logger shell.Logger, patterns []string, environment map[string]string) map[string]string {
+	return VarsToRedactWithResult(logger, patterns, environment).Redacted
+}
+
+// VarsToRedactMulti is like VarsToRedact, but for several environment maps
+// (e.g. the agent, job, and plugin environments). Where a variable is set in
+// more than one map, the value from the last map that sets it wins, and only
+// the winning value is considered for redaction.
+func VarsToRedactMulti(logger shell.Logger, patterns []string, envs ...map[string]string) map[string]string {
+	size := 0
+	for _, env := range envs {
+		size += len(env)
+	}
+	merged := make(map[string]string, size)
+	for _, env := range envs {
+		for name, val := range env {
+			merged[name] = val
+		}
+	}
+	return VarsToRedact(logger, patterns, merged)
+}
+
+// RedactedVar is the value of a variable to be redacted, and the pattern that
+// caused it to be redacted.
+type RedactedVar struct {
+	Value, Pattern string
+}
+
+// VarsToRedactWithPatterns is like VarsToRedact, but also reports the pattern
+// that matched each variable. If a variable matches multiple patterns, the
+// first matching pattern is reported. This is useful for diagnosing why a
+// variable is being redacted (log the pattern, not the value).
+func VarsToRedactWithPatterns(logger shell.Logger, patterns []string, environment map[string]string) map[string]RedactedVar {
+	return varsToRedact(logger, patterns, nil, nil, LengthBytes, environment, nil)
+}
+
+// VarsToRedactWithAllowlist is like VarsToRedact, but values in allowlist are
+// never redacted, even if the variable name matches a pattern. This is for
+// well-known values that aren't secret (e.g. a placeholder like "changeme").
+// Values must match an allowlist entry exactly (case-sensitive).
+func VarsToRedactWithAllowlist(logger shell.Logger, patterns, allowlist []string, environment map[string]string) map[string]string {
+	allowed := make(map[string]bool, len(allowlist))
+	for _, val := range allowlist {
+		allowed[val] = true
+	}
+
+	matched := varsToRedact(logger, patterns, nil, allowed, LengthBytes, environment, nil)
+	vars := make(map[string]string, len(matched))
+	for name, v := range matched {
+		vars[name] = v.Value
+	}
+	return vars
+}
+
+// LengthUnit is the unit in which the length of a value is measured, when
+// comparing it with RedactLengthMin.
+type LengthUnit int
+
+const (
+	// LengthBytes measures the length of a value in bytes. This is the
+	// default.
+	LengthBytes LengthUnit = iota
+
+	// LengthRunes measures the length of a value in printable characters
+	// (as defined by unicode.IsPrint), so that the minimum is about how
+	// guessable a value is, rather than how it is encoded. For example, "äöü"
+	// is 6 bytes long, but only 3 characters.
+	LengthRunes
+)
+
+// length returns the length of s in the unit.
+func (u LengthUnit) length(s string) int {
+	if u != LengthRunes {
+		return len(s)
+	}
+	n := 0
+	for _, c := range s {
+		if unicode.IsPrint(c) {
+			n++
+		}
+	}
+	return n
+}
+
+func (u LengthUnit) String() string {
+	if u == LengthRunes {
+		return "characters"
+	}
+	return "bytes"
+}
+
+// VarsToRedactWithLengthUnit is like VarsToRedact, but measures the length of
+// each value in unit when comparing it with RedactLengthMin.
+func VarsToRedactWithLengthUnit(logger shell.Logger, patterns []string, environment map[string]string, unit LengthUnit) map[string]string {
+	matched := varsToRedact(logger, patterns, nil, nil, unit, environment, nil)
+	vars := make(map[string]string, len(matched))
+	for name, v := range matched {
+		vars[name] = v.Value
+	}
+	return vars
+}
+
+// VarsToRedactWithExclusions is like VarsToRedact, but variables with names
+// matching any of the excluded patterns (such as "*_PUBLIC_TOKEN") are never
+// redacted, even if their names also match one of patterns. This is for
+// variables that are deliberately public, but named like secrets.
+func VarsToRedactWithExclusions(logger shell.Logger, patterns, excluded []string, environment map[string]string) map[string]string {
+	for _, pattern := range excluded {
+		if _, err := path.Match(pattern, ""); err != nil {
+			logger.Warningf("Bad redaction exclusion pattern: %s", pattern)
+		}
+	}
+
+	matched := varsToRedact(logger, patterns, excluded, nil, LengthBytes, environment, nil)
+	vars := make(map[string]string, len(matched))
+	for name, v := range matched {
+		vars[name] = v.Value
+	}
+	return vars
+}
+
+// ValidatePatterns checks that each of patterns is a valid pattern for
+// VarsToRedact, so that a bad pattern can be reported when configuration is
+// loaded, rather than as a warning each time it is used. It returns an error
+// listing every bad pattern, or nil if they are all valid.
+func ValidatePatterns(patterns []string) error {
+	var errs []error
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("bad redacted vars pattern %q: %w", pattern, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// VarsToRedactResult is the detailed result of VarsToRedactWithResult.
+type VarsToRedactResult struct {
+	// Redacted contains the names and values of variables to be redacted.
+	Redacted map[string]string
+
+	// SkippedShort contains the names of variables that matched a pattern
+	// but weren't redacted, because their values are shorter than
+	// RedactLengthMin. It is sorted.
+	SkippedShort []string
+
+	// BadPatterns contains the patterns that are malformed, in the order they
+	// were given.
+	BadPatterns []string
+}
+
+// VarsToRedactWithResult is like VarsToRedact, but also reports the variables
+// and patterns that are likely misconfigured, so that tools can flag them.
+// Like VarsToRedact, it also logs warnings about them.
+func VarsToRedactWithResult(logger shell.Logger, patterns []string, environment map[string]string) VarsToRedactResult {
+	var res VarsToRedactResult
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			res.BadPatterns = append(res.BadPatterns, pattern)
+		}
+	}
+
+	matched := varsToRedact(logger, patterns, nil, nil, LengthBytes, environment, &res)
+	res.Redacted = make(map[string]string, len(matched))
+	for name, v := range matched {
+		res.Redacted[name] = v.Value
+	}
+	sort.Strings(res.SkippedShort)
+	return res
+}
+
+// varsToRedact implements VarsToRedact and its variants. If res is not nil,
+// the names of variables skipped for being too short are appended to
+// res.SkippedShort.
+func varsToRedact(logger shell.Logger, patterns, excluded []string, allowed map[string]bool, unit LengthUnit, environment map[string]string, res *VarsToRedactResult) map[string]RedactedVar {
 	// Lifted out of Bootstrap.setupRedactors to facilitate testing
-	vars := make(map[string]string)
+	vars := make(map[string]RedactedVar)
 
 	for name, val := range environment {
+		if matchesAny(excluded, name) {
+			if matchesAny(patterns, name) {
+				logger.Commentf("%s is excluded from redaction and will not be redacted", name)
+			}
+			continue
+		}
+		short := false
 		for _, pattern := range patterns {
 			matched, err := path.Match(pattern, name)
 			if err != nil {
@@ -392,16 +2490,24 @@ func VarsToRedact(logger shell.Logger, patterns []string, environment map[string
 			if !matched {
 				continue
 			}
-			if len(val) < RedactLengthMin {
+			if allowed[val] {
+				logger.Commentf("Value of %s is allowlisted and will not be redacted", name)
+				break
+			}
+			if unit.length(val) < RedactLengthMin {
 				if len(val) > 0 {
-					logger.Warningf("Value of %s below minimum length (%d bytes) and will not be redacted", name, RedactLengthMin)
+					logger.Warningf("Value of %s below minimum length (%d %s) and will not be redacted", name, RedactLengthMin, unit)
+					short = true
 				}
 				continue
 			}
 
-			vars[name] = val
+			vars[name] = RedactedVar{Value: val, Pattern: pattern}
 			break // Break pattern loop, continue to next env var
 		}
+		if short && res != nil {
+			res.SkippedShort = append(res.SkippedShort, name)
+		}
 	}
 
 	return vars
@@ -410,12 +2516,35 @@ func VarsToRedact(logger shell.Logger, patterns []string, environment map[string
 // Mux contains multiple redactors
 type Mux []*Redactor
 
-// Flush flushes all redactors.
+// MuxFlushError is the error from flushing one of the redactors in a Mux.
+type MuxFlushError struct {
+	// Index is the index of the redactor in the Mux.
+	Index int
+
+	// Name is the name of the redactor, set with WithName (if any).
+	Name string
+
+	// Err is the error from flushing the redactor.
+	Err error
+}
+
+func (e *MuxFlushError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("flushing redactor %d (%s): %v", e.Index, e.Name, e.Err)
+	}
+	return fmt.Sprintf("flushing redactor %d: %v", e.Index, e.Err)
+}
+
+func (e *MuxFlushError) Unwrap() error { return e.Err }
+
+// Flush flushes all redactors. Each error is a *MuxFlushError identifying the
+// redactor that failed, so that errors.As can find which redactor (and so
+// which destination) failed.
 func (mux Mux) Flush() error {
 	var errs []error
-	for _, r := range mux {
+	for i, r := range mux {
 		if err := r.Flush(); err != nil {
-			errs = append(errs, err)
+			errs = append(errs, &MuxFlushError{Index: i, Name: r.opts.name, Err: err})
 		}
 	}
 	if len(errs) != 0 {
@@ -424,9 +2553,72 @@ func (mux Mux) Flush() error {
 	return nil
 }
 
-// Reset resets all redactors with new needles (secrets).
+// Reset resets all redactors with new needles (secrets), as Redactor.Reset
+// does, including the needles each redactor derives from them (see
+// WithUnicodeNormalization and similar options). The needles are bucketed once
+// for each distinct combination of those options, and the resulting NeedleSet
+// is shared by the redactors with that combination.
 func (mux Mux) Reset(needles []string) {
+	sets := make(map[expansion]*NeedleSet)
 	for _, r := range mux {
-		r.Reset(needles)
+		e := r.opts.expansion()
+		set := sets[e]
+		if set == nil {
+			set = r.opts.needleSet(NeedleSlice(needles))
+			sets[e] = set
+		}
+		r.ResetNeedleSet(set)
+	}
+}
+
+// Concat returns a new Mux containing the redactors of mux followed by those
+// of other, so that subsystems with their own Muxes can be flushed and reset
+// together. A redactor in both (or repeated in either) is only included once,
+// where it first appears, so that it isn't flushed twice. Neither mux nor
+// other is modified.
+func (mux Mux) Concat(other Mux) Mux {
+	combined := make(Mux, 0, len(mux)+len(other))
+	seen := make(map[*Redactor]bool, len(mux)+len(other))
+	for _, m := range []Mux{mux, other} {
+		for _, r := range m {
+			if seen[r] {
+				continue
+			}
+			seen[r] = true
+			combined = append(combined, r)
+		}
+	}
+	return combined
+}
+
+// SetupRedactors returns a Mux containing a Redactor for each of dsts (in the
+// same order), redacting the values of environment variables with names
+// matching patterns, replacing them with subst. A destination that is already
+// a Redactor (from an earlier call) is Reset with the values, and made to use
+// subst, and returned itself, rather than being wrapped in another. If no values need redacting,
+// the redactors pass output through unaltered until they are Reset with some
+// needles. It returns early with an error if ctx is cancelled.
+func SetupRedactors(ctx context.Context, logger shell.Logger, patterns []string, environment map[string]string, subst string, dsts ...io.Writer) (Mux, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	values := ValuesToRedact(logger, patterns, environment)
+	needles := NewNeedleSet(values)
+	if err := ValidateSubst(subst, needles); err != nil {
+		logger.Warningf("Some secrets may not be redacted from output: %v", err)
+	}
+
+	mux := make(Mux, 0, len(dsts))
+	for _, dst := range dsts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if r, ok := dst.(*Redactor); ok {
+			r.resetWithSubst(subst, values)
+			mux = append(mux, r)
+			continue
+		}
+		mux = append(mux, NewWithNeedleSet(dst, subst, needles))
 	}
+	return mux, nil
 }
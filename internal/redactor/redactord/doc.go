@@ -0,0 +1,9 @@
+// Package redactord serves redaction over a Unix domain socket, so that
+// processes not written in Go can have their output redacted by the same
+// Redactor as the agent's own.
+//
+// Each connection is an independent stream: the client writes its output, and
+// reads back the redacted output. When the client closes its side of the
+// connection for writing (or closes it entirely), the stream ends, and any
+// output held back in case it was the start of a secret is flushed.
+package redactord
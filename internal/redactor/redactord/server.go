@@ -0,0 +1,206 @@
+package redactord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/buildkite/agent/v3/internal/redactor"
+)
+
+// Server redacts streams sent to it over a Unix domain socket.
+type Server struct {
+	path    string
+	subst   string
+	needles *redactor.NeedleSet
+	opts    []redactor.Option
+
+	mu      sync.Mutex
+	ln      net.Listener
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+	started bool
+	closed  bool
+}
+
+// NewServer creates a server that, when started, will listen on a socket at
+// the given path, and redact each stream sent to it with its own Redactor,
+// created with subst, needles, and opts. It returns an error if subst contains
+// one of the needles, since it would write the needle out (see
+// redactor.ValidateSubst).
+//
+// A socket left at the path by a server that is no longer listening (for
+// example, one that crashed) is removed. Any other file at the path, including
+// a socket that is still being listened on, is an error.
+func NewServer(socketPath, subst string, needles *redactor.NeedleSet, opts ...redactor.Option) (*Server, error) {
+	if err := redactor.ValidateSubst(subst, needles); err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(socketPath); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		path:    socketPath,
+		subst:   subst,
+		needles: needles,
+		opts:    opts,
+		conns:   make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing is listening on it.
+// It returns an error if there is some other file at path, or a socket that is
+// in use.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("file already exists at socket path %s", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket at path %s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// NeedleSetFromEnv returns a NeedleSet of the values of the variables in
+// environment to be redacted, according to patterns. See
+// redactor.VarsToRedact.
+func NeedleSetFromEnv(logger shell.Logger, patterns []string, environment map[string]string) *redactor.NeedleSet {
+	vars := redactor.VarsToRedact(logger, patterns, environment)
+	values := make([]string, 0, len(vars))
+	for _, v := range vars {
+		values = append(values, v)
+	}
+	return redactor.NewNeedleSet(values)
+}
+
+// Start starts the server.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return errors.New("server already started")
+	}
+
+	ln, err := net.Listen("unix", s.path)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	s.started = true
+
+	s.wg.Add(1)
+	go s.serve(ln)
+	return nil
+}
+
+// serve accepts connections until ln is closed.
+func (s *Server) serve(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			// Accepted as the server was stopping, after Close closed the
+			// open connections.
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// handle redacts the stream read from conn, writing the redacted stream back
+// to conn, until the client stops writing.
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := redactor.NewWithNeedleSet(conn, s.subst, s.needles, s.opts...)
+	// Whether the stream ended cleanly or not, write what can be written of
+	// the rest of it. Errors writing mean the client has gone, so there is no
+	// one to report them to.
+	io.Copy(r, conn)
+	r.Flush()
+}
+
+// Close stops accepting connections, and immediately closes any open ones.
+// Prefer Shutdown for ordinary use.
+func (s *Server) Close() error {
+	if err := s.stop(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// Shutdown stops accepting connections, and waits for the open ones to end.
+// If ctx is done first, it closes them as Close does, and returns ctx.Err().
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.stop(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// stop closes the listener, which also removes the socket.
+func (s *Server) stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		return errors.New("server not started")
+	}
+	s.closed = true
+	s.ln.Close()
+	return nil
+}
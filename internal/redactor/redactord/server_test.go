@@ -0,0 +1,279 @@
+package redactord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/buildkite/agent/v3/internal/redactor"
+)
+
+var testSocketCounter uint32
+
+func testSocketPath() string {
+	id := atomic.AddUint32(&testSocketCounter, 1)
+	return filepath.Join(os.TempDir(), fmt.Sprintf("test-redactord-%d-%d", os.Getpid(), id))
+}
+
+// startServer starts a server redacting needles, which is closed when the test
+// ends.
+func startServer(t *testing.T, needles *redactor.NeedleSet) string {
+	t.Helper()
+
+	sockPath := testSocketPath()
+	svr, err := NewServer(sockPath, "[REDACTED]", needles)
+	if err != nil {
+		t.Fatalf("NewServer(%q, ...) error = %v", sockPath, err)
+	}
+	if err := svr.Start(); err != nil {
+		t.Fatalf("svr.Start() = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := svr.Shutdown(context.Background()); err != nil {
+			t.Errorf("svr.Shutdown() = %v", err)
+		}
+	})
+	return sockPath
+}
+
+// redactVia sends each of the pieces of input to the server as a separate
+// write, ends the stream, and returns the redacted stream.
+func redactVia(sockPath string, pieces ...string) (string, error) {
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	// Read concurrently, so that the server is never blocked writing back.
+	var got []byte
+	var readErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		got, readErr = io.ReadAll(conn)
+	}()
+
+	for _, p := range pieces {
+		if _, err := io.WriteString(conn, p); err != nil {
+			return "", err
+		}
+	}
+	if err := conn.(*net.UnixConn).CloseWrite(); err != nil {
+		return "", err
+	}
+	<-done
+	return string(got), readErr
+}
+
+func TestServerRedactsStream(t *testing.T) {
+	t.Parallel()
+
+	sockPath := startServer(t, redactor.NewNeedleSet([]string{"secret1111", "hunter2hunter2"}))
+
+	for _, test := range []struct {
+		desc   string
+		pieces []string
+		want   string
+	}{
+		{
+			desc:   "one write",
+			pieces: []string{"token is secret1111\n"},
+			want:   "token is [REDACTED]\n",
+		},
+		{
+			desc:   "secret split across writes",
+			pieces: []string{"password: hunter2", "hunter2 and sec", "ret1111\n"},
+			want:   "password: [REDACTED] and [REDACTED]\n",
+		},
+		{
+			desc:   "secret at end of stream",
+			pieces: []string{"no trailing newline secret1111"},
+			want:   "no trailing newline [REDACTED]",
+		},
+		{
+			desc:   "partial secret at end of stream",
+			pieces: []string{"almost secret111"},
+			want:   "almost secret111",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := redactVia(sockPath, test.pieces...)
+			if err != nil {
+				t.Fatalf("redactVia(%q, %q) error = %v", sockPath, test.pieces, err)
+			}
+			if got != test.want {
+				t.Errorf("redacted stream = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestServerConcurrentConnections(t *testing.T) {
+	t.Parallel()
+
+	sockPath := startServer(t, redactor.NewNeedleSet([]string{"secret1111"}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			input := strings.Repeat(fmt.Sprintf("conn %d: secret1111\n", i), 100)
+			want := strings.ReplaceAll(input, "secret1111", "[REDACTED]")
+			got, err := redactVia(sockPath, input)
+			if err != nil {
+				t.Errorf("connection %d: redactVia(%q, input) error = %v", i, sockPath, err)
+				return
+			}
+			if got != want {
+				t.Errorf("connection %d: redacted stream = %q, want %q", i, got, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNeedleSetFromEnv(t *testing.T) {
+	t.Parallel()
+
+	needles := NeedleSetFromEnv(shell.DiscardLogger, []string{"*_TOKEN"}, map[string]string{
+		"DEPLOY_TOKEN": "tok3n-value",
+		"GREETING":     "hello-world",
+	})
+	sockPath := startServer(t, needles)
+
+	got, err := redactVia(sockPath, "deploying with tok3n-value: hello-world\n")
+	if err != nil {
+		t.Fatalf("redactVia(%q, ...) error = %v", sockPath, err)
+	}
+	if want := "deploying with [REDACTED]: hello-world\n"; got != want {
+		t.Errorf("redacted stream = %q, want %q", got, want)
+	}
+}
+
+func TestServerAlreadyExists(t *testing.T) {
+	t.Parallel()
+
+	sockPath := startServer(t, redactor.NewNeedleSet(nil))
+	if _, err := NewServer(sockPath, "[REDACTED]", nil); err == nil {
+		t.Errorf("NewServer(%q) for an existing socket error = nil, want an error", sockPath)
+	}
+}
+
+func TestServerNotASocket(t *testing.T) {
+	t.Parallel()
+
+	sockPath := testSocketPath()
+	if err := os.WriteFile(sockPath, nil, 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v", sockPath, err)
+	}
+	t.Cleanup(func() { os.Remove(sockPath) })
+
+	if _, err := NewServer(sockPath, "[REDACTED]", nil); err == nil {
+		t.Errorf("NewServer(%q) for an existing file error = nil, want an error", sockPath)
+	}
+}
+
+func TestServerStaleSocket(t *testing.T) {
+	t.Parallel()
+
+	// Leave a socket behind, as a server that crashed would.
+	sockPath := testSocketPath()
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("net.Listen(unix, %q) error = %v", sockPath, err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	svr, err := NewServer(sockPath, "[REDACTED]", redactor.NewNeedleSet([]string{"secret1111"}))
+	if err != nil {
+		t.Fatalf("NewServer(%q) for a stale socket error = %v", sockPath, err)
+	}
+	if err := svr.Start(); err != nil {
+		t.Fatalf("svr.Start() = %v", err)
+	}
+	defer svr.Close()
+
+	got, err := redactVia(sockPath, "a secret1111\n")
+	if err != nil {
+		t.Fatalf("redactVia(%q, ...) error = %v", sockPath, err)
+	}
+	if want := "a [REDACTED]\n"; got != want {
+		t.Errorf("redacted stream = %q, want %q", got, want)
+	}
+}
+
+func TestServerCloseWhileConnecting(t *testing.T) {
+	t.Parallel()
+
+	sockPath := testSocketPath()
+	svr, err := NewServer(sockPath, "[REDACTED]", redactor.NewNeedleSet(nil))
+	if err != nil {
+		t.Fatalf("NewServer(%q, ...) error = %v", sockPath, err)
+	}
+	if err := svr.Start(); err != nil {
+		t.Fatalf("svr.Start() = %v", err)
+	}
+
+	// Clients that connect and never end their streams, some of them as the
+	// server is closing.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				conn, err := net.Dial("unix", sockPath)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+	}
+
+	closed := make(chan error)
+	go func() { closed <- svr.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("svr.Close() = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("svr.Close() didn't return with connections still open")
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestServerSubstContainsNeedle(t *testing.T) {
+	t.Parallel()
+
+	sockPath := testSocketPath()
+	_, err := NewServer(sockPath, "[REDACTED]", redactor.NewNeedleSet([]string{"DACT"}))
+	if !errors.Is(err, redactor.ErrSubstContainsNeedle) {
+		t.Errorf("NewServer(%q, %q, needles) error = %v, want %v", sockPath, "[REDACTED]", err, redactor.ErrSubstContainsNeedle)
+	}
+}
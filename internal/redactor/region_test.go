@@ -0,0 +1,86 @@
+package redactor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactorRegions(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		desc, input, want string
+		opts              []Option
+	}{
+		{
+			desc:  "region",
+			input: "before @@SECRET_START@@ anything at all @@SECRET_END@@ after\n",
+			want:  "before [REDACTED] after\n",
+		},
+		{
+			desc:  "several regions spanning lines",
+			input: "a @@SECRET_START@@x\ny@@SECRET_END@@ b @@SECRET_START@@@@SECRET_END@@ c\n",
+			want:  "a [REDACTED] b [REDACTED] c\n",
+		},
+		{
+			desc:  "end marker without start marker",
+			input: "a @@SECRET_END@@ b\n",
+			want:  "a @@SECRET_END@@ b\n",
+		},
+		{
+			desc:  "unterminated region, redacted to the end",
+			input: "a @@SECRET_START@@ secret1111 b\n",
+			want:  "a [REDACTED]",
+		},
+		{
+			desc:  "unterminated region, passed through",
+			input: "a @@SECRET_START@@ secret1111 b\n",
+			want:  "a @@SECRET_START@@ [REDACTED] b\n",
+			opts:  []Option{WithUnterminatedRegions(PassUnterminatedRegions)},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			// Split the input at every possible size, to split the markers
+			// across writes.
+			for size := 1; size <= len(test.input); size++ {
+				var buf strings.Builder
+				redactor := New(&buf, "[REDACTED]", []string{"secret1111"}, test.opts...)
+				redactor.AddRegion([]byte("@@SECRET_START@@"), []byte("@@SECRET_END@@"))
+				writeInPieces(redactor, []byte(test.input), size)
+				redactor.Flush()
+
+				if got := buf.String(); got != test.want {
+					t.Errorf("written in pieces of %d, buf.String() = %q, want %q", size, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactorRegionsKeptAcrossReset(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	redactor := New(&buf, "[REDACTED]", []string{"secret1111"})
+	redactor.AddRegion([]byte("<<"), []byte(">>"))
+	redactor.Reset([]string{"secret2222"})
+	writeInPieces(redactor, []byte("a <<b>> secret1111 secret2222\n"), 3)
+	redactor.Flush()
+
+	if got, want := buf.String(), "a [REDACTED] secret1111 [REDACTED]\n"; got != want {
+		t.Errorf("after Reset, buf.String() = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	clone := redactor.Clone(&buf)
+	clone.Reset(nil)
+	writeInPieces(clone, []byte("c <<d>> e\n"), 3)
+	clone.Flush()
+
+	if got, want := buf.String(), "c [REDACTED] e\n"; got != want {
+		t.Errorf("clone after Reset, buf.String() = %q, want %q", got, want)
+	}
+}
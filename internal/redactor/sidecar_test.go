@@ -0,0 +1,69 @@
+package redactor
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactorSidecar(t *testing.T) {
+	t.Parallel()
+
+	secrets := []string{"secret1111", "hunter2hunter2"}
+	input := "a secret1111 b hunter2hunter2\nc secret1111secret1111 d\n"
+
+	var out, sidecar strings.Builder
+	redactor := New(&out, "[REDACTED]", secrets, WithSidecar(&sidecar))
+	writeInPieces(redactor, []byte(input), 3)
+	redactor.Flush()
+
+	if got, want := out.String(), "a [REDACTED] b [REDACTED]\nc [REDACTED][REDACTED] d\n"; got != want {
+		t.Errorf("post-redaction out.String() = %q, want %q", got, want)
+	}
+	for _, secret := range secrets {
+		if strings.Contains(sidecar.String(), secret) {
+			t.Errorf("sidecar.String() = %q, contains secret %q", sidecar.String(), secret)
+		}
+	}
+
+	var records []SidecarRecord
+	sc := bufio.NewScanner(strings.NewReader(sidecar.String()))
+	for sc.Scan() {
+		var rec SidecarRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("json.Unmarshal(%q) error = %v", sc.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if got, want := len(records), 4; got != want {
+		t.Fatalf("len(records) = %d, want %d", got, want)
+	}
+
+	// Reconstruct the input from the output, the records, and a vault of the
+	// secrets by fingerprint.
+	vault := make(map[string]string)
+	for _, secret := range secrets {
+		vault[Fingerprint(secret)] = secret
+	}
+	output := out.String()
+	var rebuilt strings.Builder
+	outOffset := 0
+	for _, rec := range records {
+		if got := output[rec.OutputOffset : rec.OutputOffset+rec.OutputLength]; got != "[REDACTED]" {
+			t.Errorf("record %+v doesn't align with a substitution: output range = %q", rec, got)
+		}
+		secret := vault[rec.Fingerprint]
+		if len(secret) != rec.InputLength {
+			t.Errorf("record %+v: InputLength = %d, want len(%q)", rec, rec.InputLength, secret)
+		}
+		rebuilt.WriteString(output[outOffset:rec.OutputOffset])
+		rebuilt.WriteString(secret)
+		outOffset = rec.OutputOffset + rec.OutputLength
+	}
+	rebuilt.WriteString(output[outOffset:])
+
+	if got := rebuilt.String(); got != input {
+		t.Errorf("reconstructed input = %q, want %q", got, input)
+	}
+}
@@ -0,0 +1,50 @@
+package redactor
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// defaultSinkSubst is the substitution used by NewForCSV and NewForJSON.
+const defaultSinkSubst = "[REDACTED]"
+
+// NewForCSV returns a new Redactor whose substitution is safe to write into a
+// field of CSV or TSV output, as normalized by SubstForCSV.
+func NewForCSV(dst io.Writer, needles []string, opts ...Option) *Redactor {
+	return New(dst, SubstForCSV(defaultSinkSubst), needles, opts...)
+}
+
+// NewForJSON returns a new Redactor whose substitution is safe to write into a
+// string within JSON output, as escaped by SubstForJSON.
+func NewForJSON(dst io.Writer, needles []string, opts ...Option) *Redactor {
+	return New(dst, SubstForJSON(defaultSinkSubst), needles, opts...)
+}
+
+// SubstForCSV normalizes subst so that it cannot change the structure of CSV
+// or TSV output when it replaces part of a field: surrounding brackets are
+// trimmed, and delimiters, quotes, and line breaks are removed. If nothing is
+// left, "REDACTED" is returned.
+func SubstForCSV(subst string) string {
+	subst = strings.TrimSuffix(strings.TrimPrefix(subst, "["), "]")
+	subst = strings.Map(func(r rune) rune {
+		switch r {
+		case ',', ';', '\t', '"', '\'', '\r', '\n':
+			return -1
+		}
+		return r
+	}, subst)
+	if subst == "" {
+		return "REDACTED"
+	}
+	return subst
+}
+
+// SubstForJSON escapes subst so that it cannot change the structure of JSON
+// output when it replaces part of a string: quotes, backslashes, and control
+// characters are escaped as they would be within a JSON string.
+func SubstForJSON(subst string) string {
+	// Marshalling a string can't fail.
+	b, _ := json.Marshal(subst)
+	return string(b[1 : len(b)-1])
+}
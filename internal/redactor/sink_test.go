@@ -0,0 +1,86 @@
+package redactor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSubstForCSV(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		subst, want string
+	}{
+		{subst: "[REDACTED]", want: "REDACTED"},
+		{subst: "REDACTED", want: "REDACTED"},
+		{subst: "[a,b\t\"c\"\nd]", want: "abcd"},
+		{subst: "[,]", want: "REDACTED"},
+	} {
+		if got := SubstForCSV(test.subst); got != test.want {
+			t.Errorf("SubstForCSV(%q) = %q, want %q", test.subst, got, test.want)
+		}
+	}
+}
+
+func TestNewForCSV(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	redactor := NewForCSV(&buf, []string{"hunter2"})
+	redactor.Write([]byte("name,password,note\nalice,hunter2,pw is hunter2\n"))
+	redactor.Flush()
+
+	records, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.Reader.ReadAll() error = %v", err)
+	}
+	want := [][]string{
+		{"name", "password", "note"},
+		{"alice", "REDACTED", "pw is REDACTED"},
+	}
+	if diff := cmp.Diff(records, want); diff != "" {
+		t.Errorf("redacted CSV records diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSubstForJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, subst := range []string{"[REDACTED]", `"},{"`, "a\\b\nc\x00"} {
+		doc := `{"secret":"` + SubstForJSON(subst) + `"}`
+		var got map[string]string
+		if err := json.Unmarshal([]byte(doc), &got); err != nil {
+			t.Errorf("json.Unmarshal(%q) error = %v", doc, err)
+			continue
+		}
+		if got["secret"] != subst {
+			t.Errorf("json.Unmarshal(%q)[secret] = %q, want %q", doc, got["secret"], subst)
+		}
+	}
+}
+
+func TestNewForJSON(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	redactor := NewForJSON(&buf, []string{"hunter2"})
+	redactor.Write([]byte(`{"user":"alice","password":"hunter2","note":"pw is hunter2"}`))
+	redactor.Flush()
+
+	var got map[string]string
+	if err := json.Unmarshal([]byte(buf.String()), &got); err != nil {
+		t.Fatalf("json.Unmarshal(%q) error = %v", buf.String(), err)
+	}
+	want := map[string]string{
+		"user":     "alice",
+		"password": "[REDACTED]",
+		"note":     "pw is [REDACTED]",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("redacted JSON diff (-got +want):\n%s", diff)
+	}
+}
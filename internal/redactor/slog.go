@@ -0,0 +1,131 @@
+//go:build go1.21
+
+package redactor
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// SlogHandlerOptions are options for a SlogHandler.
+type SlogHandlerOptions struct {
+	// StringifyValues causes attribute values that aren't strings (such as
+	// numbers, errors, and fmt.Stringers) to be formatted as strings for
+	// matching. A value found to contain a secret is replaced by the redacted
+	// string. Otherwise, such values are passed on untouched.
+	StringifyValues bool
+}
+
+// SlogHandler is a slog.Handler that redacts secrets from the message and
+// string-valued attributes of each record (including attributes within
+// groups, and those added with WithAttrs), before passing it to another
+// handler. Attribute keys and group names aren't redacted.
+//
+// Each value is redacted on its own, by a copy of a Redactor, so secrets
+// aren't matched across values, and the Redactor's callbacks aren't called.
+// The copy follows the Redactor's needles, as they are Reset.
+type SlogHandler struct {
+	inner slog.Handler
+	sr    *slogRedactor
+	opts  SlogHandlerOptions
+}
+
+// NewSlogHandler returns a SlogHandler that redacts the secrets of r, with
+// r's substitution and options, from records before passing them to inner. If
+// opts is nil, the zero SlogHandlerOptions are used.
+func NewSlogHandler(inner slog.Handler, r *Redactor, opts *SlogHandlerOptions) *SlogHandler {
+	h := &SlogHandler{inner: inner, sr: &slogRedactor{r: r}}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// Enabled reports whether the inner handler handles records at level.
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+// Handle redacts a copy of rec, and passes it to the inner handler.
+func (h *SlogHandler) Handle(ctx context.Context, rec slog.Record) error {
+	msg, _ := h.sr.redact(rec.Message)
+	redacted := slog.NewRecord(rec.Time, rec.Level, msg, rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, redacted)
+}
+
+// WithAttrs returns a SlogHandler whose inner handler has the attributes,
+// redacted, added.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactAttr(a)
+	}
+	return &SlogHandler{inner: h.inner.WithAttrs(redacted), sr: h.sr, opts: h.opts}
+}
+
+// WithGroup returns a SlogHandler whose inner handler has the group opened.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	return &SlogHandler{inner: h.inner.WithGroup(name), sr: h.sr, opts: h.opts}
+}
+
+// redactAttr returns a with its value redacted.
+func (h *SlogHandler) redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		s, _ := h.sr.redact(v.String())
+		return slog.String(a.Key, s)
+
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = h.redactAttr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+
+	default:
+		if h.opts.StringifyValues {
+			if s, ok := h.sr.redact(v.String()); ok {
+				return slog.String(a.Key, s)
+			}
+		}
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+}
+
+// slogRedactor redacts values for a SlogHandler, and the handlers derived from
+// it, which share it.
+type slogRedactor struct {
+	r *Redactor
+
+	mu      sync.Mutex
+	scratch *Redactor  // copy of r, writing to buf
+	needles *NeedleSet // r's needles when scratch was copied
+	buf     bytes.Buffer
+}
+
+// redact returns s, redacted, and whether anything was redacted.
+func (sr *slogRedactor) redact(s string) (string, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if sr.scratch == nil || sr.needles != sr.r.currentNeedles() {
+		sr.scratch, sr.needles = sr.r.scratch(&sr.buf)
+	}
+	sr.buf.Reset()
+	before := sr.scratch.redactions
+	// Writing to a bytes.Buffer can't fail.
+	sr.scratch.Write([]byte(s))
+	sr.scratch.Flush()
+	if sr.scratch.redactions == before {
+		return s, false
+	}
+	return sr.buf.String(), true
+}
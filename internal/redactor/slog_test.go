@@ -0,0 +1,99 @@
+//go:build go1.21
+
+package redactor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// secretValuer is a slog.LogValuer that logs a secret.
+type secretValuer struct{}
+
+func (secretValuer) LogValue() slog.Value { return slog.StringValue("valuer secret1111") }
+
+func TestSlogHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc string
+		opts *SlogHandlerOptions
+		want string
+	}{
+		{
+			desc: "strings only",
+			want: `{"level":"INFO","msg":"login with [REDACTED]","app":"key [REDACTED]","user":"alice","token":"[REDACTED]","req":{"auth":"Bearer [REDACTED]","inner":{"pw":"[REDACTED]"},"status":401},"err":"bad secret1111","valuer":"valuer [REDACTED]"}` + "\n",
+		},
+		{
+			desc: "stringify values",
+			opts: &SlogHandlerOptions{StringifyValues: true},
+			want: `{"level":"INFO","msg":"login with [REDACTED]","app":"key [REDACTED]","user":"alice","token":"[REDACTED]","req":{"auth":"Bearer [REDACTED]","inner":{"pw":"[REDACTED]"},"status":401},"err":"bad [REDACTED]","valuer":"valuer [REDACTED]"}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			inner := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			})
+			r := New(io.Discard, "[REDACTED]", []string{"secret1111"})
+			logger := slog.New(NewSlogHandler(inner, r, test.opts)).With("app", "key secret1111")
+
+			logger.Info("login with secret1111",
+				"user", "alice",
+				"token", "secret1111",
+				slog.Group("req",
+					"auth", "Bearer secret1111",
+					slog.Group("inner", "pw", "secret1111"),
+					"status", 401,
+				),
+				"err", errors.New("bad secret1111"),
+				"valuer", secretValuer{},
+			)
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("logged:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSlogHandlerFollowsReset(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	r := New(io.Discard, "[REDACTED]", []string{"secret1111"}, WithOnRedact(func(Redaction) {
+		t.Errorf("OnRedact called by SlogHandler")
+	}))
+	logger := slog.New(NewSlogHandler(slog.NewTextHandler(&buf, nil), r, nil))
+
+	logger.Info("first", "a", "secret1111", "b", "secret2222")
+	r.Reset([]string{"secret2222"})
+	logger.Info("second", "a", "secret1111", "b", "secret2222")
+
+	got := buf.String()
+	for _, want := range []string{
+		`msg=first a=[REDACTED] b=secret2222`,
+		`msg=second a=secret1111 b=[REDACTED]`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("logged:\n%s\nwant it to contain %q", got, want)
+		}
+	}
+	if r.Stats().Redactions != 0 {
+		t.Errorf("r.Stats().Redactions = %d, want 0", r.Stats().Redactions)
+	}
+}
@@ -0,0 +1,74 @@
+package redactor
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// secretWords are the parts of variable names that suggest the variable
+// holds a secret, in upper case.
+var secretWords = []string{"TOKEN", "SECRET", "PASSWORD", "KEY"}
+
+// Suggestion is a variable that looks like it holds a secret, but whose name
+// doesn't match any redaction pattern, so it won't be redacted.
+type Suggestion struct {
+	// Name is the name of the variable.
+	Name string
+
+	// Word is the part of the name that makes it look like a secret, such as
+	// "TOKEN", in upper case.
+	Word string
+}
+
+// SuggestPatterns returns the variables in env whose names contain one of
+// the words commonly found in the names of secrets (TOKEN, SECRET, PASSWORD,
+// or KEY, in any case), but don't match any of patterns (as with
+// VarsToRedact). These are often naming mistakes, such as APITOKEN where the
+// pattern is *_TOKEN, which leave a secret unredacted. Variables with empty
+// values are skipped. The suggestions are sorted by name.
+//
+// The suggestions are advisory, and include variables that aren't secrets
+// (such as KEYBOARD_LAYOUT), so they are meant for a report for a human to
+// review, not for redacting automatically.
+func SuggestPatterns(env map[string]string, patterns []string) []Suggestion {
+	var suggestions []Suggestion
+	for name, val := range env {
+		if val == "" {
+			continue
+		}
+		word := secretWord(name)
+		if word == "" || matchesAny(patterns, name) {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{Name: name, Word: word})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		return suggestions[i].Name < suggestions[j].Name
+	})
+	return suggestions
+}
+
+// secretWord returns the first of secretWords found in name, ignoring case, or
+// "" if there is none.
+func secretWord(name string) string {
+	upper := strings.ToUpper(name)
+	for _, word := range secretWords {
+		if strings.Contains(upper, word) {
+			return word
+		}
+	}
+	return ""
+}
+
+// matchesAny reports whether name matches any of patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// path.ErrBadPattern is the only error returned by path.Match, and a
+		// bad pattern matches nothing.
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package redactor
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSuggestPatterns(t *testing.T) {
+	t.Parallel()
+
+	patterns := []string{"*_TOKEN", "*_PASSWORD", "["}
+	env := map[string]string{
+		"APITOKEN":          "llamas",
+		"GITHUB_TOKEN":      "ghp_abcdef",
+		"DB_PASSWORD":       "hunter2",
+		"db_password":       "hunter2",
+		"ClientSecret":      "shh",
+		"SSH_KEY":           "ssh-rsa-AAAA",
+		"EMPTY_SECRET":      "",
+		"BUILDKITE_COMMAND": "make",
+		"PATH":              "/usr/bin",
+	}
+
+	got := SuggestPatterns(env, patterns)
+	want := []Suggestion{
+		{Name: "APITOKEN", Word: "TOKEN"},
+		{Name: "ClientSecret", Word: "SECRET"},
+		{Name: "SSH_KEY", Word: "KEY"},
+		// Patterns are case-sensitive, so this isn't matched by *_PASSWORD.
+		{Name: "db_password", Word: "PASSWORD"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("SuggestPatterns(env, %q) diff (-got +want):\n%s", patterns, diff)
+	}
+
+	if got := SuggestPatterns(env, []string{"*"}); len(got) != 0 {
+		t.Errorf("SuggestPatterns(env, [*]) = %v, want none", got)
+	}
+}
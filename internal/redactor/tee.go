@@ -0,0 +1,54 @@
+package redactor
+
+import (
+	"io"
+	"sync"
+)
+
+// UnredactedTee writes its input to a Redactor, and also writes an unredacted
+// copy of the input to a second writer, such as a restricted audit log.
+//
+// Everything written to the unredacted writer contains secrets in the clear,
+// so it must only ever be a sink that is access controlled at least as
+// strictly as the secrets themselves.
+type UnredactedTee struct {
+	mu         sync.Mutex
+	redactor   *Redactor
+	unredacted io.Writer
+}
+
+// NewUnredactedTee returns an UnredactedTee that writes redacted output via r,
+// and unredacted output to unredacted.
+func NewUnredactedTee(r *Redactor, unredacted io.Writer) *UnredactedTee {
+	return &UnredactedTee{redactor: r, unredacted: unredacted}
+}
+
+// Write writes b to the unredacted writer, then to the Redactor. Concurrent
+// calls are serialized, so both writers receive the input in the same order.
+// The redacted output may lag behind the unredacted output, while the
+// Redactor holds back potential secrets.
+//
+// If the unredacted writer fails, the part of b that it wrote is still written
+// to the Redactor, so that a caller retrying with the rest of b doesn't lose
+// that part from the redacted output.
+func (t *UnredactedTee) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, err := t.unredacted.Write(b)
+	if err != nil {
+		if rn, rerr := t.redactor.Write(b[:n]); rerr != nil {
+			return rn, rerr
+		}
+		return n, err
+	}
+	return t.redactor.Write(b)
+}
+
+// Flush flushes the Redactor.
+func (t *UnredactedTee) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.redactor.Flush()
+}
@@ -0,0 +1,86 @@
+package redactor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUnredactedTee(t *testing.T) {
+	t.Parallel()
+
+	input := "login with secret1111\nthen again: secret1111\n"
+
+	var redacted, unredacted strings.Builder
+	tee := NewUnredactedTee(New(&redacted, "[REDACTED]", []string{"secret1111"}), &unredacted)
+	writeInPieces(tee, []byte(input), 3)
+	tee.Flush()
+
+	if got, want := unredacted.String(), input; got != want {
+		t.Errorf("unredacted.String() = %q, want %q", got, want)
+	}
+	if got, want := redacted.String(), "login with [REDACTED]\nthen again: [REDACTED]\n"; got != want {
+		t.Errorf("redacted.String() = %q, want %q", got, want)
+	}
+}
+
+func TestUnredactedTeeUnredactedWriteErrorThenRetry(t *testing.T) {
+	t.Parallel()
+
+	input := "login with secret1111\nthen again: secret1111\n"
+
+	// Fail after every possible number of bytes of unredacted output.
+	for budget := 0; budget <= len(input); budget++ {
+		var redacted strings.Builder
+		unredacted := &flakyWriter{budget: budget}
+		tee := NewUnredactedTee(New(&redacted, "[REDACTED]", []string{"secret1111"}), unredacted)
+
+		n, err := tee.Write([]byte(input))
+		if budget < len(input) {
+			if !errors.Is(err, errFlaky) {
+				t.Fatalf("budget %d: tee.Write() error = %v, want %v", budget, err, errFlaky)
+			}
+			// Retry the rest, as io.Writer allows.
+			unredacted.budget = len(input)
+			if _, err := tee.Write([]byte(input[n:])); err != nil {
+				t.Fatalf("budget %d: tee.Write(rest) error = %v", budget, err)
+			}
+		}
+		tee.Flush()
+
+		if got, want := unredacted.String(), input; got != want {
+			t.Errorf("budget %d: unredacted.String() = %q, want %q", budget, got, want)
+		}
+		if got, want := redacted.String(), "login with [REDACTED]\nthen again: [REDACTED]\n"; got != want {
+			t.Errorf("budget %d: redacted.String() = %q, want %q", budget, got, want)
+		}
+	}
+}
+
+func TestUnredactedTeeOrdering(t *testing.T) {
+	t.Parallel()
+
+	var redacted, unredacted strings.Builder
+	tee := NewUnredactedTee(New(&redacted, "[REDACTED]", []string{"secret1111"}), &unredacted)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				fmt.Fprintf(tee, "%d:%d secret1111\n", g, i)
+			}
+		}()
+	}
+	wg.Wait()
+	tee.Flush()
+
+	// Both sinks have the same lines in the same order.
+	if got, want := redacted.String(), strings.ReplaceAll(unredacted.String(), "secret1111", "[REDACTED]"); got != want {
+		t.Errorf("redacted output is not the unredacted output, redacted and in the same order")
+	}
+}
@@ -0,0 +1,58 @@
+package redactor
+
+import (
+	"bytes"
+
+	"golang.org/x/text/transform"
+)
+
+// Transformer adapts a Redactor to the transform.Transformer interface, so
+// that redaction can be composed with other transformations (for example,
+// with transform.Chain), or used with transform.NewReader and
+// transform.NewWriter.
+//
+// Input that could be part of a secret is held back inside the Transformer
+// (as it is by a Redactor), so Transform consumes all of src. It returns
+// transform.ErrShortSrc if it is holding input back, and needs more of it
+// (or atEOF) to make progress. It returns transform.ErrShortDst if the
+// output doesn't fit in dst; the rest is returned by the next call.
+type Transformer struct {
+	r   *Redactor
+	out bytes.Buffer
+}
+
+var _ transform.Transformer = (*Transformer)(nil)
+
+// NewTransformer returns a Transformer that redacts needles, replacing them
+// with subst, as New does.
+func NewTransformer(subst string, needles []string, opts ...Option) *Transformer {
+	t := &Transformer{}
+	t.r = New(&t.out, subst, needles, opts...)
+	return t
+}
+
+// Transform implements transform.Transformer.
+func (t *Transformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	// Writing to a bytes.Buffer can't fail.
+	t.r.Write(src)
+	if atEOF {
+		t.r.Flush()
+	}
+
+	nDst = copy(dst, t.out.Bytes())
+	t.out.Next(nDst)
+	switch {
+	case t.out.Len() > 0:
+		err = transform.ErrShortDst
+	case !atEOF && t.r.Stats().Buffered > 0:
+		err = transform.ErrShortSrc
+	}
+	return nDst, len(src), err
+}
+
+// Reset implements transform.Transformer. It discards any input held back
+// and output not yet returned, ready for a new stream. The needles are kept.
+func (t *Transformer) Reset() {
+	t.out.Reset()
+	t.r = t.r.Clone(&t.out)
+}
@@ -0,0 +1,105 @@
+package redactor
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestTransformerReader(t *testing.T) {
+	t.Parallel()
+
+	input := "a secret1111 b\nsecret1111"
+	want := "a [REDACTED] b\n[REDACTED]"
+
+	tr := NewTransformer("[REDACTED]", []string{"secret1111"})
+	got, err := io.ReadAll(transform.NewReader(iotest.OneByteReader(strings.NewReader(input)), tr))
+	if err != nil {
+		t.Fatalf("io.ReadAll(transform.NewReader(input)) error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("io.ReadAll(transform.NewReader(input)) = %q, want %q", got, want)
+	}
+
+	// After Reset, the transformer can be reused for another stream.
+	tr.Reset()
+	got, _, err = transform.Bytes(tr, []byte(input))
+	if err != nil || string(got) != want {
+		t.Errorf("after Reset, transform.Bytes(input) = %q, %v, want %q, nil", got, err, want)
+	}
+}
+
+func TestTransformerWriter(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	w := transform.NewWriter(&buf, NewTransformer("[REDACTED]", []string{"secret1111"}))
+	writeInPieces(w, []byte("a secret1111 b secret"), 3)
+	if got, want := buf.String(), "a [REDACTED] b "; got != want {
+		t.Errorf("before Close, buf.String() = %q, want %q", got, want)
+	}
+
+	// Close calls Transform with atEOF, ending the partial match.
+	if err := w.Close(); err != nil {
+		t.Errorf("w.Close() = %v", err)
+	}
+	if got, want := buf.String(), "a [REDACTED] b secret"; got != want {
+		t.Errorf("after Close, buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestTransformerChain(t *testing.T) {
+	t.Parallel()
+
+	// The secret is decomposed in the input, so it only matches after NFC
+	// normalization.
+	secret := norm.NFC.String("pässwörd-crème")
+	input := "pw=" + norm.NFD.String(secret) + "\n"
+
+	chain := transform.Chain(norm.NFC, NewTransformer("[REDACTED]", []string{secret}))
+	got, _, err := transform.String(chain, input)
+	if err != nil {
+		t.Fatalf("transform.String(chain, input) error = %v", err)
+	}
+	if want := "pw=[REDACTED]\n"; got != want {
+		t.Errorf("transform.String(chain, input) = %q, want %q", got, want)
+	}
+}
+
+func TestTransformerShortBuffers(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTransformer("[REDACTED]", []string{"secret1111"})
+
+	// Part of a secret is held back, which needs more input.
+	dst := make([]byte, 64)
+	nDst, nSrc, err := tr.Transform(dst, []byte("ab secr"), false)
+	if got := string(dst[:nDst]); got != "ab " || nSrc != 7 || !errors.Is(err, transform.ErrShortSrc) {
+		t.Errorf("tr.Transform(dst, %q, false) = %q, %d, %v, want %q, 7, %v", "ab secr", got, nSrc, err, "ab ", transform.ErrShortSrc)
+	}
+
+	// The substitution doesn't fit in a small dst, so it is returned over
+	// several calls.
+	var out strings.Builder
+	src := []byte("et1111 cd")
+	small := make([]byte, 4)
+	for {
+		nDst, nSrc, err := tr.Transform(small, src, true)
+		out.Write(small[:nDst])
+		src = src[nSrc:]
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, transform.ErrShortDst) {
+			t.Fatalf("tr.Transform(small, src, true) error = %v, want %v", err, transform.ErrShortDst)
+		}
+	}
+	if got, want := out.String(), "[REDACTED] cd"; got != want {
+		t.Errorf("output over several Transforms = %q, want %q", got, want)
+	}
+}
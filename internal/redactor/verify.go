@@ -0,0 +1,142 @@
+package redactor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LeakPolicy is what a VerifiedWriter does when it finds a needle in its
+// output.
+type LeakPolicy int
+
+const (
+	// LeakReturnsError causes Write to return a *LeakError.
+	LeakReturnsError LeakPolicy = iota
+
+	// LeakPanics causes Write to panic with a *LeakError.
+	LeakPanics
+)
+
+// LeakError reports a needle found by a VerifiedWriter in its output. It
+// identifies the needle by fingerprint, so that it can be logged.
+type LeakError struct {
+	// Fingerprint of the needle (see Fingerprint).
+	Fingerprint string
+
+	// Offset of the needle in the output.
+	Offset int64
+}
+
+func (e *LeakError) Error() string {
+	return fmt.Sprintf("secret with fingerprint %s written to output at offset %d", e.Fingerprint, e.Offset)
+}
+
+// VerifiedWriter checks the output of a Redactor: it writes to dst, and then
+// scans the bytes written for needles, independently of the Redactor. Finding
+// one means the Redactor (or how it was configured) is wrong, and is handled
+// according to the LeakPolicy. By then the needle has already been written,
+// so VerifiedWriter detects leaks, it doesn't prevent them.
+//
+// Verifying is about as much work again as redacting, so it is meant for
+// tests and high-assurance deployments. The scan is a plain substring search,
+// so it also reports needles that the Redactor was configured to leave alone,
+// such as with WithFirstOccurrenceOnly, Needle.WordBoundary or
+// Needle.Delimiters.
+type VerifiedWriter struct {
+	mu      sync.Mutex
+	dst     io.Writer
+	needles [][]byte
+	policy  LeakPolicy
+
+	// The last bytes written, in which a needle may have begun.
+	tail   []byte
+	maxLen int
+
+	written int64
+	leaked  int64
+}
+
+// NewVerifiedWriter returns a VerifiedWriter that writes to dst, checking for
+// needles. Use it as the destination of a Redactor with the same needles.
+func NewVerifiedWriter(dst io.Writer, policy LeakPolicy, needles []string) *VerifiedWriter {
+	w := &VerifiedWriter{dst: dst, policy: policy}
+	for _, s := range needles {
+		if s == "" {
+			continue
+		}
+		w.needles = append(w.needles, []byte(s))
+		if len(s) > w.maxLen {
+			w.maxLen = len(s)
+		}
+	}
+	return w
+}
+
+// Write writes b to dst, then scans what was written for needles, including
+// needles split across calls to Write. An error from dst is returned in
+// preference to a leak.
+func (w *VerifiedWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.dst.Write(b)
+	leak := w.scan(b[:n])
+	if leak != nil && w.policy == LeakPanics {
+		panic(leak)
+	}
+	if err != nil {
+		return n, err
+	}
+	if leak != nil {
+		return n, leak
+	}
+	return n, nil
+}
+
+// scan looks for needles ending in b, which follows the bytes in tail. It
+// returns the first one found, if any.
+func (w *VerifiedWriter) scan(b []byte) *LeakError {
+	window := append(w.tail, b...)
+	base := w.written - int64(len(w.tail))
+
+	var first *LeakError
+	for _, needle := range w.needles {
+		// Occurrences that end within tail were found by an earlier scan.
+		from := len(w.tail) - len(needle) + 1
+		if from < 0 {
+			from = 0
+		}
+		for from <= len(window)-len(needle) {
+			i := bytes.Index(window[from:], needle)
+			if i < 0 {
+				break
+			}
+			offset := base + int64(from+i)
+			w.leaked += int64(len(needle))
+			if first == nil || offset < first.Offset {
+				first = &LeakError{Fingerprint: Fingerprint(string(needle)), Offset: offset}
+			}
+			from += i + 1
+		}
+	}
+
+	w.written += int64(len(b))
+	if keep := w.maxLen - 1; keep <= 0 {
+		window = nil
+	} else if len(window) > keep {
+		window = window[len(window)-keep:]
+	}
+	w.tail = append(w.tail[:0], window...)
+	return first
+}
+
+// LeakedBytes returns the total length of the needles found in the output so
+// far. It is 0 unless something leaked.
+func (w *VerifiedWriter) LeakedBytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.leaked
+}
@@ -0,0 +1,106 @@
+package redactor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifiedWriterNoLeak(t *testing.T) {
+	t.Parallel()
+
+	needles := []string{"secret1111", "hunter2"}
+	input := "login with secret1111 and hunter2, then secret1111 again\n"
+
+	for _, piece := range []int{1, 3, len(input)} {
+		var buf strings.Builder
+		verified := NewVerifiedWriter(&buf, LeakReturnsError, needles)
+		redactor := New(verified, "[REDACTED]", needles)
+		writeInPieces(redactor, []byte(input), piece)
+		if err := redactor.Flush(); err != nil {
+			t.Errorf("pieces of %d: redactor.Flush() = %v", piece, err)
+		}
+		if got := verified.LeakedBytes(); got != 0 {
+			t.Errorf("pieces of %d: verified.LeakedBytes() = %d, want 0", piece, got)
+		}
+	}
+}
+
+func TestVerifiedWriterCatchesLeak(t *testing.T) {
+	t.Parallel()
+
+	// The substitution contains the needle, which the Redactor doesn't check
+	// (see ValidateSubst), so every redaction leaks the secret.
+	needles := []string{"secret1111"}
+	subst := "<secret1111>"
+	input := "login with secret1111\n"
+
+	var buf strings.Builder
+	verified := NewVerifiedWriter(&buf, LeakReturnsError, needles)
+	redactor := New(verified, subst, needles)
+	// The leak is reported by whichever call writes the redaction to dst.
+	_, err := redactor.Write([]byte(input))
+	if err == nil {
+		err = redactor.Flush()
+	}
+
+	var leak *LeakError
+	if !errors.As(err, &leak) {
+		t.Fatalf("redacting with a leaky substitution: error = %v, want a *LeakError", err)
+	}
+	if want := (LeakError{Fingerprint: Fingerprint("secret1111"), Offset: 12}); *leak != want {
+		t.Errorf("leak = %+v, want %+v", *leak, want)
+	}
+	if strings.Contains(err.Error(), "secret1111") {
+		t.Errorf("err.Error() = %q, contains the secret", err.Error())
+	}
+	if got, want := verified.LeakedBytes(), int64(len("secret1111")); got != want {
+		t.Errorf("verified.LeakedBytes() = %d, want %d", got, want)
+	}
+}
+
+func TestVerifiedWriterSplitAcrossWrites(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	verified := NewVerifiedWriter(&buf, LeakReturnsError, []string{"secret1111", "s"})
+	var errs []error
+	for _, piece := range []string{"xx sec", "ret1", "111 yy"} {
+		if _, err := verified.Write([]byte(piece)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// "s" is found in the first write, and "secret1111" in the last.
+	want := []error{
+		&LeakError{Fingerprint: Fingerprint("s"), Offset: 3},
+		&LeakError{Fingerprint: Fingerprint("secret1111"), Offset: 3},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors from verified.Write() = %v, want %v", errs, want)
+	}
+	for i := range errs {
+		if *errs[i].(*LeakError) != *want[i].(*LeakError) {
+			t.Errorf("error %d from verified.Write() = %v, want %v", i, errs[i], want[i])
+		}
+	}
+	if got, want := buf.String(), "xx secret1111 yy"; got != want {
+		t.Errorf("buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestVerifiedWriterPanics(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	verified := NewVerifiedWriter(&buf, LeakPanics, []string{"secret1111"})
+	redactor := New(verified, "<secret1111>", []string{"secret1111"})
+
+	defer func() {
+		if _, ok := recover().(*LeakError); !ok {
+			t.Errorf("redacting with a leaky substitution didn't panic with a *LeakError")
+		}
+	}()
+	redactor.Write([]byte("login with secret1111\n"))
+	redactor.Flush()
+}